@@ -0,0 +1,312 @@
+package streammatch
+
+import (
+	"io"
+)
+
+//Maximum number of each edit operation allowed in an approximate match.
+//A negative operation limit means the operation is only bounded by Total
+type EditLimits struct {
+	Insertions    int
+	Deletions     int
+	Substitutions int
+	Total         int
+}
+
+//Wu-Manber's k-error Shift-And, as used by agrep
+type Agrep struct {
+	pattern []byte
+	words   int
+	//Bit i of masks[c*words:] is set when pattern[i] == c
+	masks []uint64
+
+	//Each row of states has a budget of edits, and comes after the rows
+	//whose budgets have one operation less. totals[s] is the number of edits of row s
+	totals []int
+	//Row with one insertion, deletion or substitution less (-1 if none)
+	insfrom []int
+	delfrom []int
+	subfrom []int
+	//Rows whose matches are not all found by another row
+	final []int
+	//Set when only the total is limited, and there are rows
+	totalonly bool
+
+	//States
+	//Bit i of states[s*words:] is set when pattern[:i+1] matches a suffix
+	//of the text read so far within the budget of row s
+	states []uint64
+	old    []uint64
+
+	//stream
+	offset    int
+	buf       []byte
+	buflen    int
+	bufcursor int
+
+	//err
+	lasterr error
+}
+
+//Creates a matcher that allows up to maxdist edits of any kind.
+//Reports the same matches as Sellers
+func NewAgrep(pattern []byte, maxdist int) *Agrep {
+	return NewAgrepLimits(pattern, EditLimits{Insertions: -1, Deletions: -1, Substitutions: -1, Total: maxdist})
+}
+
+//Creates a matcher that limits each edit operation separately. Limiting an
+//operation takes a row for each budget of edits instead of one for each total,
+//so it is much slower than limiting only the total
+func NewAgrepLimits(pattern []byte, limits EditLimits) *Agrep {
+	plen := len(pattern)
+	words := (plen + wordSize - 1) / wordSize
+
+	masks := make([]uint64, 256*words)
+	for i, c := range pattern {
+		masks[int(c)*words+i/wordSize] |= 1 << uint(i%wordSize)
+	}
+
+	ag := &Agrep{
+		pattern: pattern,
+		words:   words,
+		masks:   masks,
+		buf:     make([]byte, defaultBufSize),
+	}
+
+	limit := func(l int) int {
+		if l < 0 || l > limits.Total {
+			return limits.Total
+		}
+		return l
+	}
+	maxins, maxdel, maxsub := limit(limits.Insertions), limit(limits.Deletions), limit(limits.Substitutions)
+
+	if maxins == limits.Total && maxdel == limits.Total && maxsub == limits.Total {
+		//Only the total is limited: row d allows d edits of any kind
+		for d := 0; d <= limits.Total; d++ {
+			ag.totals = append(ag.totals, d)
+			ag.insfrom = append(ag.insfrom, d-1)
+			ag.delfrom = append(ag.delfrom, d-1)
+			ag.subfrom = append(ag.subfrom, d-1)
+		}
+		if limits.Total >= 0 {
+			ag.final = []int{limits.Total}
+			ag.totalonly = true
+		}
+	} else {
+		budgets := agrep_computeBudgets(maxins, maxdel, maxsub, limits.Total)
+		index := make(map[[3]int]int, len(budgets))
+		for s, budget := range budgets {
+			index[budget] = s
+		}
+		from := func(budget [3]int, op int) int {
+			budget[op]--
+			if s, ok := index[budget]; ok {
+				return s
+			}
+			return -1
+		}
+
+		for s, budget := range budgets {
+			ag.totals = append(ag.totals, budget[0]+budget[1]+budget[2])
+			ag.insfrom = append(ag.insfrom, from(budget, 0))
+			ag.delfrom = append(ag.delfrom, from(budget, 1))
+			ag.subfrom = append(ag.subfrom, from(budget, 2))
+			ag.final = append(ag.final, s)
+		}
+	}
+
+	ag.states = make([]uint64, len(ag.totals)*words)
+	ag.old = make([]uint64, len(ag.totals)*words)
+	ag.Reset()
+	return ag
+}
+
+//Every (insertions, deletions, substitutions) budget allowed by the limits.
+//A budget comes after all budgets that have one operation less
+func agrep_computeBudgets(maxins int, maxdel int, maxsub int, total int) [][3]int {
+	budgets := make([][3]int, 0)
+	for ins := 0; ins <= maxins; ins++ {
+		for del := 0; del <= maxdel && ins+del <= total; del++ {
+			for sub := 0; sub <= maxsub && ins+del+sub <= total; sub++ {
+				budgets = append(budgets, [3]int{ins, del, sub})
+			}
+		}
+	}
+	return budgets
+}
+
+//dst = src << 1, with the empty prefix always matching
+func agrep_shift(dst []uint64, src []uint64) {
+	carry := uint64(1)
+	for w := range src {
+		next := src[w] >> (wordSize - 1)
+		dst[w] = (src[w] << 1) | carry
+		carry = next
+	}
+}
+
+func (ag *Agrep) Reset() {
+	//Before any text only deletions are possible
+	words := ag.words
+	for s := range ag.totals {
+		state := ag.states[s*words : (s+1)*words]
+		for w := range state {
+			state[w] = 0
+		}
+		if i := ag.delfrom[s]; i >= 0 {
+			agrep_shift(state, ag.states[i*words:(i+1)*words])
+		}
+	}
+
+	ag.offset = 0
+	ag.buflen = 0
+	ag.bufcursor = 0
+}
+
+//Reads the next byte and reports if the whole pattern matches
+func (ag *Agrep) advance(char byte) bool {
+	words := ag.words
+	ag.states, ag.old = ag.old, ag.states
+	if words == 1 {
+		return ag.advanceWord(char)
+	}
+
+	mask := ag.masks[int(char)*words : (int(char)+1)*words]
+	for s := range ag.totals {
+		state := ag.states[s*words : (s+1)*words]
+
+		//Match
+		agrep_shift(state, ag.old[s*words:(s+1)*words])
+		for w := range state {
+			state[w] &= mask[w]
+		}
+
+		//Insertion: the byte is consumed but the pattern does not advance
+		if i := ag.insfrom[s]; i >= 0 {
+			ins := ag.old[i*words : (i+1)*words]
+			for w := range state {
+				state[w] |= ins[w]
+			}
+		}
+
+		//Substitution: the byte is consumed by any pattern position
+		if i := ag.subfrom[s]; i >= 0 {
+			sub := ag.old[i*words : (i+1)*words]
+			carry := uint64(1)
+			for w := range state {
+				state[w] |= (sub[w] << 1) | carry
+				carry = sub[w] >> (wordSize - 1)
+			}
+		}
+
+		//Deletion: the pattern advances without consuming the byte
+		if i := ag.delfrom[s]; i >= 0 {
+			del := ag.states[i*words : (i+1)*words]
+			carry := uint64(1)
+			for w := range state {
+				state[w] |= (del[w] << 1) | carry
+				carry = del[w] >> (wordSize - 1)
+			}
+		}
+	}
+
+	plen := len(ag.pattern)
+	if plen == 0 {
+		return len(ag.totals) > 0
+	}
+	matchword, matchbit := (plen-1)/wordSize, uint64(1)<<uint((plen-1)%wordSize)
+	for _, s := range ag.final {
+		if ag.states[s*words+matchword]&matchbit != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//advance for patterns that fit in a single word
+func (ag *Agrep) advanceWord(char byte) bool {
+	states, old := ag.states, ag.old
+	mask := ag.masks[char]
+	matchbit := uint64(1) << uint(len(ag.pattern)-1)
+	if ag.totalonly {
+		//R'[d] = match(R[d]) | insert(R[d-1]) | substitute(R[d-1]) | delete(R'[d-1])
+		prev := (old[0]<<1 | 1) & mask
+		states[0] = prev
+		for d := 1; d < len(states); d++ {
+			prev = (old[d]<<1|1)&mask | old[d-1] | old[d-1]<<1 | prev<<1 | 1
+			states[d] = prev
+		}
+		return prev&matchbit != 0
+	}
+
+	for s := range ag.totals {
+		state := (old[s]<<1 | 1) & mask
+		if i := ag.insfrom[s]; i >= 0 {
+			state |= old[i]
+		}
+		if i := ag.subfrom[s]; i >= 0 {
+			state |= old[i]<<1 | 1
+		}
+		if i := ag.delfrom[s]; i >= 0 {
+			state |= states[i]<<1 | 1
+		}
+		states[s] = state
+	}
+
+	for _, s := range ag.final {
+		if states[s]&matchbit != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//Least number of edits of the budgets where the whole pattern matches
+//the last bytes read, one more than the largest budget if there is none
+func (ag *Agrep) MatchDistance() int {
+	plen := len(ag.pattern)
+	best, most := -1, 0
+	for s, total := range ag.totals {
+		if total > most {
+			most = total
+		}
+		matched := plen == 0 || ag.states[s*ag.words+(plen-1)/wordSize]&(1<<uint((plen-1)%wordSize)) != 0
+		if matched && (best < 0 || total < best) {
+			best = total
+		}
+	}
+	if best < 0 {
+		return most + 1
+	}
+	return best
+}
+
+func (ag *Agrep) FindMatch(reader io.Reader) (int, error) {
+	for {
+		if ag.bufcursor >= ag.buflen {
+			if ag.lasterr != nil {
+				lasterr := ag.lasterr
+				ag.lasterr = nil
+				return -1, lasterr
+			}
+			ag.offset += ag.buflen
+			ag.buflen, ag.lasterr = reader.Read(ag.buf)
+			ag.bufcursor = 0
+		}
+
+		for ag.bufcursor < ag.buflen {
+			matched := ag.advance(ag.buf[ag.bufcursor])
+			ag.bufcursor++
+			if matched {
+				return ag.offset + ag.bufcursor - 1, nil
+			}
+		}
+
+		if ag.lasterr != nil {
+			lasterr := ag.lasterr
+			ag.lasterr = nil
+			return -1, lasterr
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package streammatch
+
+//Edges of a trie, as stored by each representation of AhoCorasick
+type ahoTrie interface {
+	//Returns the child of state by char, 0 if there is none
+	child(state int, char byte) int
+	//Calls visit for each child of state
+	edges(state int, visit func(char byte, child int))
+}
+
+//Trie with a row of 256 children for each state, see aho_computeTrie
+type ahoDenseTrie [][256]int
+
+func (trie ahoDenseTrie) child(state int, char byte) int {
+	return trie[state][char]
+}
+
+func (trie ahoDenseTrie) edges(state int, visit func(char byte, child int)) {
+	for char := 0; char < 256; char++ {
+		if child := trie[state][char]; child != 0 {
+			visit(byte(char), child)
+		}
+	}
+}
+
+//Trie stored in a double array over byte classes. Only the bytes found in
+//the patterns get a class, and the edge of state by class c is in slot
+//base[state]+c when check[base[state]+c] == state. The states of sparse
+//rows share the slots, so the trie takes a few int32 per state and edge.
+//The children of each state are also kept in a list, to walk its edges
+type ahoCompactTrie struct {
+	//classes[c] is the class of byte c, 0 when no pattern has it
+	classes [256]int32
+	//Classes, counting class 0
+	numclasses int
+
+	base []int32
+	//check[t] is the state owning slot t, -1 if free
+	check []int32
+	//next[t] is the child in slot t
+	next []int32
+
+	//First child of each state and next child of its parent, -1 if none
+	firstchild []int32
+	sibling    []int32
+	//Byte of the edge to each state
+	label []byte
+}
+
+//Builds the compact trie of patterns, and the pattern that ends at each state, -1 if none.
+//The states are numbered as by aho_computeTrie
+func aho_computeCompactTrie(patterns [][]byte) (*ahoCompactTrie, []int32) {
+	trie := &ahoCompactTrie{numclasses: 1}
+	for _, pattern := range patterns {
+		for _, char := range pattern {
+			if trie.classes[char] == 0 {
+				trie.classes[char] = int32(trie.numclasses)
+				trie.numclasses++
+			}
+		}
+	}
+
+	//First the trie is built with the lists of children
+	firstchild := []int32{-1}
+	sibling := []int32{-1}
+	label := []byte{0}
+	occurrences := []int32{-1}
+	for p, pattern := range patterns {
+		cur_state := int32(0)
+		for _, char := range pattern {
+			child := firstchild[cur_state]
+			for child != -1 && label[child] != char {
+				child = sibling[child]
+			}
+			if child == -1 {
+				child = int32(len(occurrences))
+				firstchild = append(firstchild, -1)
+				sibling = append(sibling, firstchild[cur_state])
+				label = append(label, char)
+				occurrences = append(occurrences, -1)
+				firstchild[cur_state] = child
+			}
+			cur_state = child
+		}
+		occurrences[cur_state] = int32(p)
+	}
+	trie.firstchild, trie.sibling, trie.label = firstchild, sibling, label
+
+	//Then each row is placed at the first base where its slots are free.
+	//nextfree[t] leads to the first free slot from t on, and the slots
+	//past the end of check are all free
+	num_states := len(occurrences)
+	trie.base = make([]int32, num_states)
+	var nextfree []int32
+	findfree := func(slot int) int {
+		for slot < len(nextfree) && int(nextfree[slot]) != slot {
+			next := int(nextfree[slot])
+			if next < len(nextfree) {
+				nextfree[slot] = nextfree[next]
+			}
+			slot = next
+		}
+		return slot
+	}
+	classes := make([]int32, 0, trie.numclasses)
+	for state := 0; state < num_states; state++ {
+		classes = classes[:0]
+		least := int32(trie.numclasses)
+		for child := firstchild[state]; child != -1; child = sibling[child] {
+			class := trie.classes[label[child]]
+			classes = append(classes, class)
+			if class < least {
+				least = class
+			}
+		}
+		if len(classes) == 0 {
+			continue
+		}
+
+		//The least class goes in a free slot, try them until the others fit
+		base := 0
+		for free := findfree(int(least)); ; free = findfree(free + 1) {
+			base = free - int(least)
+			fits := true
+			for _, class := range classes {
+				slot := base + int(class)
+				if slot < len(trie.check) && trie.check[slot] != -1 {
+					fits = false
+					break
+				}
+			}
+			if fits {
+				break
+			}
+		}
+
+		trie.base[state] = int32(base)
+		for child := firstchild[state]; child != -1; child = sibling[child] {
+			slot := base + int(trie.classes[label[child]])
+			for slot >= len(trie.check) {
+				nextfree = append(nextfree, int32(len(trie.check)))
+				trie.check = append(trie.check, -1)
+				trie.next = append(trie.next, 0)
+			}
+			trie.check[slot] = int32(state)
+			trie.next[slot] = child
+			nextfree[slot] = int32(slot + 1)
+		}
+	}
+
+	return trie, occurrences
+}
+
+func (trie *ahoCompactTrie) child(state int, char byte) int {
+	class := trie.classes[char]
+	if class == 0 {
+		return 0
+	}
+	slot := int(trie.base[state]) + int(class)
+	if slot < len(trie.check) && int(trie.check[slot]) == state {
+		return int(trie.next[slot])
+	}
+	return 0
+}
+
+func (trie *ahoCompactTrie) edges(state int, visit func(char byte, child int)) {
+	for child := trie.firstchild[state]; child != -1; child = trie.sibling[child] {
+		visit(trie.label[child], int(child))
+	}
+}
@@ -5,14 +5,42 @@ import (
 	"io"
 )
 
+//How an AhoCorasick follows the bytes that have no edge in the trie
+type AhoMode int
+
+const (
+	//Chooses AhoFullDFA or AhoCompact from the size of the trie
+	AhoAuto AhoMode = iota
+	//Follows the failure links until a state has an edge for the byte
+	AhoFailureLinks
+	//Precomputes the complete goto function, so each byte costs a single lookup
+	AhoFullDFA
+	//Follows the failure links of a compact trie, see ahoCompactTrie.
+	//It takes a small fraction of the memory of the other modes, for large pattern sets
+	AhoCompact
+)
+
+//AhoAuto makes a full DFA of tries with up to this many states, 20 MiB of
+//rows of 256 ints, and a compact trie of larger ones. The benchmarks count
+//the states of the trie as len(occurrences), as this limit does. The full DFA
+//is the fastest up to 8k states, but from 12k states its rows spread the walk
+//over more memory than the caches hold and the compact trie is faster.
+//Following the failure links over the rows is never the fastest, so AhoAuto
+//does not choose it
+const ahoFullDFAMaxStates = 10 << 10
+
 type AhoCorasick struct {
 	patterns [][]byte
 
 	//States
-	trie            [][256]int
-	failfunction    []int
-	occurrences     []int
-	occurrence_last []int
+	//With a full DFA, trie holds the complete goto function
+	trie [][256]int
+	//Used instead of trie by AhoCompact
+	compact         *ahoCompactTrie
+	failfunction    []int32
+	occurrences     []int32
+	occurrence_last []int32
+	fulldfa         bool
 
 	//stream
 	offset    int
@@ -23,12 +51,41 @@ type AhoCorasick struct {
 
 	//err
 	lasterr error
+
+	//Case folding, nil when matching is case sensitive
+	folder *mappedReader
 }
 
 func NewAhoCorasick(patterns [][]byte) *AhoCorasick {
+	return NewAhoCorasickMode(patterns, AhoAuto)
+}
+
+func NewAhoCorasickMode(patterns [][]byte, mode AhoMode) *AhoCorasick {
+	var trie [][256]int
+	var compact *ahoCompactTrie
+	var occurrences, failfunction, occurrences_last []int32
+	if mode == AhoAuto || mode == AhoCompact {
+		//The compact trie is cheap to build, and tells the number of states
+		compact, occurrences = aho_computeCompactTrie(patterns)
+		if mode == AhoAuto {
+			mode = AhoCompact
+			if len(occurrences) <= ahoFullDFAMaxStates {
+				mode = AhoFullDFA
+			}
+		}
+	}
+
+	if mode == AhoCompact {
+		failfunction, occurrences_last = aho_computeFailFunction(compact, occurrences)
+	} else {
+		compact = nil
+		trie, occurrences = aho_computeTrie(patterns)
+		failfunction, occurrences_last = aho_computeFailFunction(ahoDenseTrie(trie), occurrences)
+	}
 
-	trie, occurrences := aho_computeTrie(patterns)
-	failfunction, occurrences_last := aho_computeFailFunction(trie, occurrences)
+	if mode == AhoFullDFA {
+		aho_computeGoto(trie, failfunction)
+	}
 	// fmt.Printf("%v\n%v\n%v\n", occurrences, failfunction, occurrences_last)
 
 	bsize := defaultBufSize
@@ -40,19 +97,33 @@ func NewAhoCorasick(patterns [][]byte) *AhoCorasick {
 	return &AhoCorasick{
 		patterns:        patterns,
 		trie:            trie,
+		compact:         compact,
 		failfunction:    failfunction,
 		occurrences:     occurrences,
 		occurrence_last: occurrences_last,
+		fulldfa:         mode == AhoFullDFA,
 		buf:             buf,
 	}
 }
 
-func aho_computeTrie(patterns [][]byte) ([][256]int, []int) {
+//Case-insensitive AhoCorasick. The trie is built over the folded patterns,
+//see NewKMPFold
+func NewAhoCorasickFold(patterns [][]byte) *AhoCorasick {
+	folded := make([][]byte, len(patterns))
+	for i, pattern := range patterns {
+		folded[i] = fold_bytes(pattern)
+	}
+	aho := NewAhoCorasick(folded)
+	aho.folder = newMappedReader(fold_transform)
+	return aho
+}
+
+func aho_computeTrie(patterns [][]byte) ([][256]int, []int32) {
 	num_patterns := len(patterns)
 
 	num_states := 0
 	trie := make([][256]int, 0, num_patterns)
-	occurrences := make([]int, 0, num_patterns)
+	occurrences := make([]int32, 0, num_patterns)
 
 	num_states++
 	trie = append(trie, [256]int{})
@@ -74,57 +145,78 @@ func aho_computeTrie(patterns [][]byte) ([][256]int, []int) {
 			}
 			cur_state = trie[cur_state][char]
 		}
-		occurrences[cur_state] = p
+		occurrences[cur_state] = int32(p)
 	}
 
 	return trie, occurrences
 }
 
-func aho_computeFailFunction(trie [][256]int, occurrences []int) ([]int, []int) {
+func aho_computeFailFunction(trie ahoTrie, occurrences []int32) ([]int32, []int32) {
 	num_states := len(occurrences)
-	failfunction := make([]int, num_states)
-	occurrence_pointer := make([]int, num_states)
+	failfunction := make([]int32, num_states)
+	occurrence_pointer := make([]int32, num_states)
 
 	last := 0
-	queue := make([]int, num_states)
+	queue := make([]int32, num_states)
 
 	queue[last] = 0
 	last++
 
 	//For each state in the queue
 	for next := 0; next < last; next++ {
-		state := queue[next]
-		for char := 0; char < 256; char++ {
-			if trie[state][char] != 0 {
-				next_state := trie[state][char]
+		state := int(queue[next])
+		trie.edges(state, func(char byte, next_state int) {
+			//Search for previous that match the character
+			fail := int(failfunction[state])
+			for fail != 0 && trie.child(fail, char) == 0 {
+				fail = int(failfunction[fail])
+			}
+
+			fail = trie.child(fail, char)
 
-				//Search for previous that match the character
-				fail := failfunction[state]
-				for fail != 0 && trie[fail][char] == 0 {
-					fail = failfunction[fail]
+			if fail != next_state {
+				failfunction[next_state] = int32(fail)
+
+				//Search for previous that has occurrence
+				occur := int32(fail)
+				for occur != 0 && occurrences[occur] == -1 {
+					occur = occurrence_pointer[occur]
 				}
+				occurrence_pointer[next_state] = occur
+			}
 
-				fail = trie[fail][char]
+			//Push the state to the queue
+			queue[last] = int32(next_state)
+			last++
+		})
+	}
 
-				if fail != next_state {
-					failfunction[next_state] = fail
+	return failfunction, occurrence_pointer
+}
 
-					//Search for previous that has occurrence
-					occur := fail
-					for occur != 0 && occurrences[occur] == -1 {
-						occur = occurrence_pointer[occur]
-					}
-					occurrence_pointer[next_state] = occur
-				}
+//Fills in the missing edges of trie with the goto function:
+//the edge of the failure state, which is already complete as it is shallower
+func aho_computeGoto(trie [][256]int, failfunction []int32) {
+	num_states := len(trie)
 
-				//Push the state to the queue
-				queue[last] = next_state
-				last++
+	//Breadth first order of the states, before any edge is added
+	queue := make([]int, 1, num_states)
+	for next := 0; next < len(queue); next++ {
+		for char := 0; char < 256; char++ {
+			if child := trie[queue[next]][char]; child != 0 {
+				queue = append(queue, child)
 			}
 		}
 	}
 
-	return failfunction, occurrence_pointer
+	for _, state := range queue[1:] {
+		fail := failfunction[state]
+		for char := 0; char < 256; char++ {
+			if trie[state][char] == 0 {
+				trie[state][char] = trie[fail][char]
+			}
+		}
+	}
 }
 
 func (aho *AhoCorasick) Reset() {
@@ -132,9 +224,58 @@ func (aho *AhoCorasick) Reset() {
 	aho.offset = 0
 	aho.buflen = 0
 	aho.bufcursor = 0
+	if aho.folder != nil {
+		aho.folder.Reset()
+	}
+}
+
+//Follows the failure links until char can be read
+func (aho *AhoCorasick) next(state int, char byte) int {
+	if aho.fulldfa {
+		return aho.trie[state][char]
+	}
+	if aho.compact != nil {
+		for state != 0 && aho.compact.child(state, char) == 0 {
+			state = int(aho.failfunction[state])
+		}
+		return aho.compact.child(state, char)
+	}
+	for state != 0 && aho.trie[state][char] == 0 {
+		state = int(aho.failfunction[state])
+	}
+
+	return aho.trie[state][char]
+}
+
+func (aho *AhoCorasick) hasOccurrences(state int) bool {
+	return aho.occurrences[state] != -1 || aho.occurrences[aho.occurrence_last[state]] != -1
+}
+
+//Appends the patterns that end at state to occur, longest first
+func (aho *AhoCorasick) collect(state int, occur []int) []int {
+	st := state
+	for {
+		if aho.occurrences[st] != -1 {
+			occur = append(occur, int(aho.occurrences[st]))
+		}
+		if st == 0 {
+			break
+		}
+		st = int(aho.occurrence_last[st])
+	}
+	return occur
 }
 
 func (aho *AhoCorasick) FindMultipleMatches(reader io.Reader) (int, []int, error) {
+	if aho.folder != nil {
+		aho.folder.source = reader
+		offset, occur, err := aho.findMultipleMatches(aho.folder)
+		return aho.folder.originalOffset(offset), occur, err
+	}
+	return aho.findMultipleMatches(reader)
+}
+
+func (aho *AhoCorasick) findMultipleMatches(reader io.Reader) (int, []int, error) {
 
 	state, offset := aho.state, aho.offset
 	buflen, bufcursor := aho.buflen, aho.bufcursor
@@ -153,30 +294,11 @@ func (aho *AhoCorasick) FindMultipleMatches(reader io.Reader) (int, []int, error
 		}
 		// fmt.Printf("AHOBUF: %v\n", string(aho.buf[bufcursor:buflen]))
 		for bufcursor < buflen {
-			next := aho.buf[bufcursor]
-
-			for state != 0 && aho.trie[state][next] == 0 {
-				state = aho.failfunction[state]
-			}
-
-			state = aho.trie[state][next]
+			state = aho.next(state, aho.buf[bufcursor])
 
 			//Has occurrences
-			if aho.occurrences[state] != -1 || aho.occurrences[aho.occurrence_last[state]] != -1 {
-
-				occur := make([]int, 0)
-
-				//Collect occurrences
-				st := state
-				for {
-					if aho.occurrences[st] != -1 {
-						occur = append(occur, aho.occurrences[st])
-					}
-					if st == 0 {
-						break
-					}
-					st = aho.occurrence_last[st]
-				}
+			if aho.hasOccurrences(state) {
+				occur := aho.collect(state, make([]int, 0))
 
 				//Save state
 				aho.state, aho.offset = state, offset
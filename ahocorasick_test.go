@@ -0,0 +1,72 @@
+package streammatch
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+//Random patterns whose trie has about states states, and a MiB of random
+//text with one of them every 64 bytes or so, so the walk goes deep into the trie
+func aho_benchInput(states int) ([][]byte, []byte) {
+	r := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		text := make([]byte, n)
+		for i := range text {
+			text[i] = byte('a' + r.Intn(26))
+		}
+		return text
+	}
+
+	//Each distinct prefix of the patterns is a state, the root is the empty one
+	prefixes := map[string]bool{"": true}
+	patterns := make([][]byte, 0)
+	for len(prefixes) < states {
+		pattern := random(8 + r.Intn(9))
+		patterns = append(patterns, pattern)
+		for i := 1; i <= len(pattern); i++ {
+			prefixes[string(pattern[:i])] = true
+		}
+	}
+
+	text := make([]byte, 0, 1<<20)
+	for len(text) < 1<<20 {
+		text = append(text, random(r.Intn(64))...)
+		text = append(text, patterns[r.Intn(len(patterns))]...)
+	}
+	return patterns, text
+}
+
+//Labels each size with the number of states of the trie that was built,
+//the quantity ahoFullDFAMaxStates is compared with
+func benchmarkAhoMode(b *testing.B, mode AhoMode) {
+	for _, states := range []int{1 << 8, 1 << 10, 1 << 12, 1 << 13, 3 << 12, 1 << 14, 1 << 15, 1 << 16} {
+		patterns, text := aho_benchInput(states)
+		aho := NewAhoCorasickMode(patterns, mode)
+		b.Run(fmt.Sprintf("states=%d", len(aho.occurrences)), func(b *testing.B) {
+			b.SetBytes(int64(len(text)))
+			for i := 0; i < b.N; i++ {
+				aho.Reset()
+				reader := bytes.NewReader(text)
+				for {
+					if _, _, err := aho.FindMultipleMatches(reader); err != nil {
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAhoFailureLinks(b *testing.B) {
+	benchmarkAhoMode(b, AhoFailureLinks)
+}
+
+func BenchmarkAhoFullDFA(b *testing.B) {
+	benchmarkAhoMode(b, AhoFullDFA)
+}
+
+func BenchmarkAhoCompact(b *testing.B) {
+	benchmarkAhoMode(b, AhoCompact)
+}
@@ -0,0 +1,237 @@
+package streammatch
+
+import (
+	"strconv"
+	"unicode/utf8"
+)
+
+//Finds the best alignment of pattern with a suffix of text, as reported by
+//Sellers at the end of text. Returns the leftmost start of the suffixes with
+//the least distance, and that distance. costs may be nil for unit costs.
+//The distances are computed backwards from the end of text, over the
+//reversed pattern and text
+func ApproxMatchStart(pattern []byte, text []byte, costs *EditCosts) (int, int) {
+	if costs == nil {
+		costs = &EditCosts{Insertion: 1, Deletion: 1, Substitution: 1}
+	}
+	return approx_matchStart(pattern, text, costs)
+}
+
+//Same as ApproxMatchStart with unit costs, but each edit inserts, deletes
+//or substitutes a whole rune, as counted by RuneSellers. The start is
+//returned as an offset in bytes of text
+func ApproxRuneMatchStart(pattern []byte, text []byte) (int, int) {
+	start, distance := approx_matchStart(rune_decode(pattern), rune_decode(text), &EditCosts{Insertion: 1, Deletion: 1, Substitution: 1})
+	return rune_byteOffset(text, start), distance
+}
+
+//Offset in bytes of the rune at index r of rune_decode(text)
+func rune_byteOffset(text []byte, r int) int {
+	//rune_decode makes a rune of each step of DecodeRune
+	offset := 0
+	for ; r > 0 && offset < len(text); r-- {
+		_, size := utf8.DecodeRune(text[offset:])
+		offset += size
+	}
+	return offset
+}
+
+//Dynamic programming of ApproxMatchStart over bytes or runes.
+//The substitution table is only used over bytes
+func approx_matchStart[T byte | rune](pattern []T, text []T, costs *EditCosts) (int, int) {
+	plen, tlen := len(pattern), len(text)
+
+	//columns[0][i] is the distance between the last i symbols of pattern
+	//and the last j symbols of text, columns[1] and columns[2] are for j-1 and j-2
+	var columns [3][]int
+	for c := range columns {
+		columns[c] = make([]int, plen+1)
+	}
+	for i := range columns[0] {
+		columns[0][i] = i * costs.Deletion
+	}
+
+	start, best := tlen, columns[0][plen]
+	for j := 1; j <= tlen; j++ {
+		columns[0], columns[1], columns[2] = columns[2], columns[0], columns[1]
+		cur, prev, prev2 := columns[0], columns[1], columns[2]
+		char := text[tlen-j]
+
+		cur[0] = j * costs.Insertion
+		least := cur[0]
+		for i := 1; i <= plen; i++ {
+			pc := pattern[plen-i]
+
+			val := prev[i-1]
+			if pc != char {
+				if costs.SubstitutionTable != nil {
+					val += costs.SubstitutionTable[char][pc]
+				} else {
+					val += costs.Substitution
+				}
+			}
+			if ins := prev[i] + costs.Insertion; ins < val {
+				val = ins
+			}
+			if del := cur[i-1] + costs.Deletion; del < val {
+				val = del
+			}
+			if costs.Transposition > 0 && i >= 2 && j >= 2 &&
+				pc == text[tlen-j+1] && pattern[plen-i+1] == char {
+				if tr := prev2[i-2] + costs.Transposition; tr < val {
+					val = tr
+				}
+			}
+			cur[i] = val
+			if val < least {
+				least = val
+			}
+		}
+
+		if cur[plen] <= best {
+			start, best = tlen-j, cur[plen]
+		}
+
+		//Every later distance comes from these two columns, so it cannot be less
+		for _, val := range prev {
+			if val < least {
+				least = val
+			}
+		}
+		if least > best {
+			break
+		}
+	}
+	return start, best
+}
+
+//Returns the start and the distance of the best alignment of the
+//pattern ending at the end of text, see ApproxMatchStart.
+//For a matcher created by NewSellersFold, text must be folded
+func (sel *Sellers) MatchStart(text []byte) (int, int) {
+	return ApproxMatchStart(sel.pattern, text, sel.costs)
+}
+
+//Returns the start in bytes and the distance in runes of the best alignment
+//of the pattern ending at the end of text, see ApproxRuneMatchStart
+func (sel *RuneSellers) MatchStart(text []byte) (int, int) {
+	start, distance := approx_matchStart(sel.pattern, rune_decode(text), &EditCosts{Insertion: 1, Deletion: 1, Substitution: 1})
+	return rune_byteOffset(text, start), distance
+}
+
+//Operation of an alignment, named after its CIGAR letter
+type EditOp byte
+
+const (
+	EditMatch        EditOp = '='
+	EditSubstitution EditOp = 'X'
+	//A text byte missing from the pattern
+	EditInsertion EditOp = 'I'
+	//A pattern byte missing from the text
+	EditDeletion EditOp = 'D'
+	//Two adjacent bytes swapped. It is not a standard CIGAR operation
+	EditTransposition EditOp = 'T'
+)
+
+//Alignment of a pattern with text[Start:End]
+type Alignment struct {
+	Start    int
+	End      int
+	Distance int
+	//Operations in text order. A transposition stands for two bytes of
+	//the pattern and of the text
+	Ops []EditOp
+}
+
+//Run-length encoding of the operations, such as "3=1X2=1I"
+func (al *Alignment) Cigar() string {
+	cigar := make([]byte, 0, 2*len(al.Ops))
+	for i := 0; i < len(al.Ops); {
+		j := i
+		for j < len(al.Ops) && al.Ops[j] == al.Ops[i] {
+			j++
+		}
+		cigar = strconv.AppendInt(cigar, int64(j-i), 10)
+		cigar = append(cigar, byte(al.Ops[i]))
+		i = j
+	}
+	return string(cigar)
+}
+
+//Aligns pattern with the suffix of text found by ApproxMatchStart,
+//and returns the operations of a least cost alignment
+func ApproxAlign(pattern []byte, text []byte, costs *EditCosts) Alignment {
+	if costs == nil {
+		costs = &EditCosts{Insertion: 1, Deletion: 1, Substitution: 1}
+	}
+	start, distance := ApproxMatchStart(pattern, text, costs)
+	region := text[start:]
+	plen, rlen := len(pattern), len(region)
+
+	subcost := func(char byte, pc byte) int {
+		if char == pc {
+			return 0
+		} else if costs.SubstitutionTable != nil {
+			return costs.SubstitutionTable[char][pc]
+		}
+		return costs.Substitution
+	}
+	transposed := func(j int, i int) bool {
+		return costs.Transposition > 0 && i >= 2 && j >= 2 &&
+			pattern[i-1] == region[j-2] && pattern[i-2] == region[j-1]
+	}
+
+	//dist[j][i] is the distance between region[:j] and pattern[:i]
+	dist := make([][]int, rlen+1)
+	for j := range dist {
+		dist[j] = make([]int, plen+1)
+		dist[j][0] = j * costs.Insertion
+	}
+	for i := 0; i <= plen; i++ {
+		dist[0][i] = i * costs.Deletion
+	}
+	for j := 1; j <= rlen; j++ {
+		for i := 1; i <= plen; i++ {
+			val := dist[j-1][i-1] + subcost(region[j-1], pattern[i-1])
+			if ins := dist[j-1][i] + costs.Insertion; ins < val {
+				val = ins
+			}
+			if del := dist[j][i-1] + costs.Deletion; del < val {
+				val = del
+			}
+			if transposed(j, i) && dist[j-2][i-2]+costs.Transposition < val {
+				val = dist[j-2][i-2] + costs.Transposition
+			}
+			dist[j][i] = val
+		}
+	}
+
+	//Trace back from the end, preferring matches and substitutions
+	ops := make([]EditOp, 0, plen+rlen)
+	for j, i := rlen, plen; j > 0 || i > 0; {
+		val := dist[j][i]
+		switch {
+		case j > 0 && i > 0 && val == dist[j-1][i-1]+subcost(region[j-1], pattern[i-1]):
+			if region[j-1] == pattern[i-1] {
+				ops = append(ops, EditMatch)
+			} else {
+				ops = append(ops, EditSubstitution)
+			}
+			j, i = j-1, i-1
+		case transposed(j, i) && val == dist[j-2][i-2]+costs.Transposition:
+			ops = append(ops, EditTransposition)
+			j, i = j-2, i-2
+		case j > 0 && val == dist[j-1][i]+costs.Insertion:
+			ops = append(ops, EditInsertion)
+			j--
+		default:
+			ops = append(ops, EditDeletion)
+			i--
+		}
+	}
+	for a, b := 0, len(ops)-1; a < b; a, b = a+1, b-1 {
+		ops[a], ops[b] = ops[b], ops[a]
+	}
+
+	return Alignment{Start: start, End: len(text), Distance: distance, Ops: ops}
+}
@@ -0,0 +1,302 @@
+package streammatch
+
+import (
+	"io"
+	"sort"
+)
+
+//Occurrence of a piece inside a pattern
+type approxPiece struct {
+	pattern int
+	//Index right after the piece in the pattern
+	end int
+}
+
+//Approximate multi-pattern matcher that filters before verifying.
+//A match with up to maxdist errors contains one of maxdist+1 pieces of
+//its pattern exactly, so only the text around the occurrences of the pieces
+//found by Aho-Corasick is verified with the Sellers DP.
+//Reports the same matches as MultiMyers
+type ApproxFilter struct {
+	patterns [][]byte
+	maxdist  int
+
+	aho *AhoCorasick
+	//pieces[u] lists where the u-th pattern of aho appears in the patterns
+	pieces [][]approxPiece
+	//Patterns too short to be split, they are always verified
+	unsplit []int
+	//Verification lags this many bytes behind the scan,
+	//so every candidate found by the scan is still ahead of it
+	lag int
+
+	//States
+	ahostate int
+	//Each pattern is verified from[p] through until[p], with the DP started at from[p]
+	from    []int
+	until   []int
+	running []bool
+	//Patterns with pending or running verification
+	scheduled []bool
+	active    []int
+	dist      [][]int
+	//Distances of the patterns of the last match
+	distances []int
+
+	//stream
+	//The last lag+1 bytes scanned
+	history  []byte
+	scanned  int
+	verified int
+	//Set when the reader failed and the rest of the scanned bytes is being verified
+	flushing  bool
+	buf       []byte
+	buflen    int
+	bufcursor int
+
+	//err
+	lasterr error
+}
+
+func NewApproxFilter(patterns [][]byte, maxdist int) *ApproxFilter {
+	num_patterns := len(patterns)
+
+	pieceindex := make(map[string]int)
+	pieces := make([][]approxPiece, 0)
+	unique := make([][]byte, 0)
+	unsplit := make([]int, 0)
+	maxlen := 0
+
+	for p, pattern := range patterns {
+		plen := len(pattern)
+		if plen > maxlen {
+			maxlen = plen
+		}
+		if maxdist < 0 {
+			continue
+		}
+		if plen < maxdist+1 {
+			unsplit = append(unsplit, p)
+			continue
+		}
+
+		//Split the pattern in maxdist+1 pieces of about the same length
+		for j := 0; j <= maxdist; j++ {
+			start, end := j*plen/(maxdist+1), (j+1)*plen/(maxdist+1)
+			piece := pattern[start:end]
+
+			u, ok := pieceindex[string(piece)]
+			if !ok {
+				u = len(unique)
+				pieceindex[string(piece)] = u
+				unique = append(unique, piece)
+				pieces = append(pieces, nil)
+			}
+			pieces[u] = append(pieces[u], approxPiece{pattern: p, end: end})
+		}
+	}
+
+	lag := maxlen
+	if maxdist > 0 {
+		lag += maxdist
+	}
+
+	dist := make([][]int, num_patterns)
+	for p, pattern := range patterns {
+		dist[p] = make([]int, len(pattern)+1)
+	}
+
+	f := &ApproxFilter{
+		patterns:  patterns,
+		maxdist:   maxdist,
+		aho:       NewAhoCorasick(unique),
+		pieces:    pieces,
+		unsplit:   unsplit,
+		lag:       lag,
+		from:      make([]int, num_patterns),
+		until:     make([]int, num_patterns),
+		running:   make([]bool, num_patterns),
+		scheduled: make([]bool, num_patterns),
+		dist:      dist,
+		history:   make([]byte, lag+1),
+		buf:       make([]byte, defaultBufSize),
+	}
+	f.Reset()
+	return f
+}
+
+func (f *ApproxFilter) Reset() {
+	f.ahostate = 0
+	for p := range f.patterns {
+		f.from[p], f.until[p] = 0, -1
+		f.running[p] = false
+		f.scheduled[p] = false
+	}
+	f.active = f.active[:0]
+
+	//Short patterns are verified everywhere
+	maxint := int(^uint(0) >> 1)
+	for _, p := range f.unsplit {
+		f.from[p], f.until[p] = 0, maxint
+		f.scheduled[p] = true
+		f.active = append(f.active, p)
+	}
+
+	f.scanned = 0
+	f.verified = 0
+	f.flushing = false
+	f.buflen = 0
+	f.bufcursor = 0
+}
+
+//Asks for pattern p to be verified from through until
+func (f *ApproxFilter) schedule(p int, from int, until int) {
+	//Only after a flush the candidate may start before the verification
+	if from < f.verified {
+		from = f.verified
+	}
+
+	if f.scheduled[p] {
+		//Extend the current verification, it only gets
+		//an earlier start if it did not start yet
+		if from < f.from[p] {
+			f.from[p] = from
+		}
+		if until > f.until[p] {
+			f.until[p] = until
+		}
+		return
+	}
+
+	f.from[p], f.until[p] = from, until
+	f.running[p] = false
+	f.scheduled[p] = true
+	f.active = append(f.active, p)
+}
+
+//Scans the next byte looking for pieces
+func (f *ApproxFilter) scan(char byte) {
+	pos := f.scanned
+	f.history[pos%len(f.history)] = char
+	f.scanned++
+
+	f.ahostate = f.aho.next(f.ahostate, char)
+	if !f.aho.hasOccurrences(f.ahostate) {
+		return
+	}
+
+	for _, u := range f.aho.collect(f.ahostate, make([]int, 0)) {
+		for _, piece := range f.pieces[u] {
+			//The match may have up to maxdist extra bytes on each side
+			plen := len(f.patterns[piece.pattern])
+			from := pos - piece.end + 1 - f.maxdist
+			until := pos + plen - piece.end + f.maxdist
+			f.schedule(piece.pattern, from, until)
+		}
+	}
+}
+
+//Verifies the byte at pos and returns the patterns that match ending there
+func (f *ApproxFilter) verify(pos int) []int {
+	char := f.history[pos%len(f.history)]
+
+	var occur []int
+	remaining := f.active[:0]
+	for _, p := range f.active {
+		if pos < f.from[p] {
+			remaining = append(remaining, p)
+			continue
+		}
+
+		pattern := f.patterns[p]
+		plen := len(pattern)
+		dist := f.dist[p]
+		if !f.running[p] {
+			for i := 0; i <= plen; i++ {
+				dist[i] = i
+			}
+			f.running[p] = true
+		}
+
+		//Sellers DP column, the first row is always 0
+		diag := dist[0]
+		for i := 1; i <= plen; i++ {
+			old := dist[i]
+			val := old + 1
+			if dist[i-1]+1 < val {
+				val = dist[i-1] + 1
+			}
+			if pattern[i-1] == char {
+				if diag < val {
+					val = diag
+				}
+			} else if diag+1 < val {
+				val = diag + 1
+			}
+			dist[i] = val
+			diag = old
+		}
+
+		if dist[plen] <= f.maxdist {
+			occur = append(occur, p)
+		}
+
+		if pos < f.until[p] {
+			remaining = append(remaining, p)
+		} else {
+			f.running[p] = false
+			f.scheduled[p] = false
+		}
+	}
+	f.active = remaining
+
+	sort.Ints(occur)
+	f.distances = f.distances[:0]
+	for _, p := range occur {
+		f.distances = append(f.distances, f.dist[p][len(f.patterns[p])])
+	}
+	return occur
+}
+
+func (f *ApproxFilter) MatchDistances() []int {
+	return f.distances
+}
+
+func (f *ApproxFilter) FindMultipleMatches(reader io.Reader) (int, []int, error) {
+	for {
+		//Verify what no further candidate can reach
+		limit := f.scanned - f.lag
+		if f.flushing {
+			limit = f.scanned
+		}
+		for f.verified < limit {
+			pos := f.verified
+			occur := f.verify(pos)
+			f.verified++
+			if len(occur) > 0 {
+				return pos, occur, nil
+			}
+		}
+
+		if f.flushing {
+			//Everything was verified, report the error
+			f.flushing = false
+			lasterr := f.lasterr
+			f.lasterr = nil
+			return -1, nil, lasterr
+		}
+
+		if f.bufcursor >= f.buflen {
+			if f.lasterr != nil {
+				f.flushing = true
+				continue
+			}
+			f.buflen, f.lasterr = reader.Read(f.buf)
+			f.bufcursor = 0
+			continue
+		}
+
+		f.scan(f.buf[f.bufcursor])
+		f.bufcursor++
+	}
+}
@@ -0,0 +1,267 @@
+package streammatch
+
+import (
+	"io"
+)
+
+//Reports a single match for each run of matches ending at consecutive
+//offsets, the one with the least distance, the leftmost among ties.
+//Approximate matchers report every end position within maxdist of the
+//pattern, so one occurrence of it usually shows up as a run of matches
+type BestMatcher struct {
+	matcher DistanceMatcher
+	//Runs only end at the errors of the reader, see NewLineBestMatcher
+	lines bool
+
+	//States
+	//Best match of the current run, -1 if there is no run
+	best     int
+	bestdist int
+	//Last match of the current run
+	last int
+	//Distance of the last match returned
+	dist int
+
+	//err
+	lasterr error
+}
+
+func NewBestMatcher(matcher DistanceMatcher) *BestMatcher {
+	return &BestMatcher{matcher: matcher, best: -1}
+}
+
+//Reports a single match between errors of the reader, such as the EOL
+//of a LineReader, so each line gets only its best match
+func NewLineBestMatcher(matcher DistanceMatcher) *BestMatcher {
+	return &BestMatcher{matcher: matcher, best: -1, lines: true}
+}
+
+func (bm *BestMatcher) Reset() {
+	bm.matcher.Reset()
+	bm.best = -1
+}
+
+func (bm *BestMatcher) MatchDistance() int {
+	return bm.dist
+}
+
+func (bm *BestMatcher) FindMatch(reader io.Reader) (int, error) {
+	for {
+		if bm.lasterr != nil {
+			//The run cannot continue after an error
+			if bm.best >= 0 {
+				best := bm.best
+				bm.best, bm.dist = -1, bm.bestdist
+				return best, nil
+			}
+			lasterr := bm.lasterr
+			bm.lasterr = nil
+			return -1, lasterr
+		}
+
+		offset, err := bm.matcher.FindMatch(reader)
+		if err != nil {
+			bm.lasterr = err
+			continue
+		}
+		dist := bm.matcher.MatchDistance()
+
+		if bm.best >= 0 && (bm.lines || offset == bm.last+1) {
+			if dist < bm.bestdist {
+				bm.best, bm.bestdist = offset, dist
+			}
+			bm.last = offset
+			continue
+		}
+
+		//A new run starts, so the previous one is over
+		best := bm.best
+		bm.dist = bm.bestdist
+		bm.best, bm.bestdist, bm.last = offset, dist, offset
+		if best >= 0 {
+			return best, nil
+		}
+	}
+}
+
+//A run of matches of one pattern, see BestMatcher
+type bestRun struct {
+	pattern int
+	best    int
+	dist    int
+	last    int
+}
+
+//Reports a single match for each run of matches of a pattern ending at
+//consecutive offsets, see BestMatcher. The runs of different patterns
+//are independent, and matches are still reported in increasing offsets
+type MultiBestMatcher struct {
+	matcher MultiDistanceMatcher
+	//Runs only end at the errors of the reader, see NewLineBestMatcher
+	lines bool
+
+	//States
+	//Runs that may still grow
+	open []bestRun
+	//Runs that are over, sorted by their best match and pattern
+	closed []bestRun
+	//Distances of the last match returned
+	distances []int
+
+	//err
+	lasterr error
+}
+
+func NewMultiBestMatcher(matcher MultiDistanceMatcher) *MultiBestMatcher {
+	return &MultiBestMatcher{matcher: matcher}
+}
+
+//Reports a single match of each pattern between errors of the reader, see NewLineBestMatcher
+func NewMultiLineBestMatcher(matcher MultiDistanceMatcher) *MultiBestMatcher {
+	return &MultiBestMatcher{matcher: matcher, lines: true}
+}
+
+func (bm *MultiBestMatcher) Reset() {
+	bm.matcher.Reset()
+	bm.open = bm.open[:0]
+	bm.closed = bm.closed[:0]
+}
+
+func (bm *MultiBestMatcher) MatchDistances() []int {
+	return bm.distances
+}
+
+//Moves a run to the closed runs, keeping them sorted
+func (bm *MultiBestMatcher) close(run bestRun) {
+	i := len(bm.closed)
+	bm.closed = append(bm.closed, run)
+	for ; i > 0; i-- {
+		prev := bm.closed[i-1]
+		if prev.best < run.best || (prev.best == run.best && prev.pattern < run.pattern) {
+			break
+		}
+		bm.closed[i] = prev
+	}
+	bm.closed[i] = run
+}
+
+//Adds the matches ending at offset to the runs
+func (bm *MultiBestMatcher) extend(offset int, occur []int, distances []int) {
+	open := bm.open[:0]
+	for _, run := range bm.open {
+		k := 0
+		for k < len(occur) && occur[k] != run.pattern {
+			k++
+		}
+		if k < len(occur) && (bm.lines || offset == run.last+1) {
+			if distances[k] < run.dist {
+				run.best, run.dist = offset, distances[k]
+			}
+			run.last = offset
+		} else if !bm.lines {
+			bm.close(run)
+			continue
+		}
+		open = append(open, run)
+	}
+
+	for k, p := range occur {
+		continued := false
+		for _, run := range open {
+			if run.pattern == p {
+				continued = true
+				break
+			}
+		}
+		if !continued {
+			open = append(open, bestRun{pattern: p, best: offset, dist: distances[k], last: offset})
+		}
+	}
+	bm.open = open
+}
+
+func (bm *MultiBestMatcher) FindMultipleMatches(reader io.Reader) (int, []int, error) {
+	for {
+		//An open run only gets a later best, and new runs start after it
+		limit := int(^uint(0) >> 1)
+		for _, run := range bm.open {
+			if run.best < limit {
+				limit = run.best
+			}
+		}
+
+		if len(bm.closed) > 0 && bm.closed[0].best < limit {
+			offset := bm.closed[0].best
+			var occur []int
+			bm.distances = bm.distances[:0]
+			n := 0
+			for n < len(bm.closed) && bm.closed[n].best == offset {
+				occur = append(occur, bm.closed[n].pattern)
+				bm.distances = append(bm.distances, bm.closed[n].dist)
+				n++
+			}
+			bm.closed = bm.closed[:copy(bm.closed, bm.closed[n:])]
+			return offset, occur, nil
+		}
+
+		if bm.lasterr != nil {
+			//The runs cannot continue after an error
+			if len(bm.open) > 0 {
+				for _, run := range bm.open {
+					bm.close(run)
+				}
+				bm.open = bm.open[:0]
+				continue
+			}
+			lasterr := bm.lasterr
+			bm.lasterr = nil
+			return -1, nil, lasterr
+		}
+
+		offset, occur, err := bm.matcher.FindMultipleMatches(reader)
+		if err != nil {
+			bm.lasterr = err
+			continue
+		}
+		bm.extend(offset, occur, bm.matcher.MatchDistances())
+	}
+}
+
+//Best match of a line, see BestLines
+type LineMatch struct {
+	//Line number, starting at 0
+	Line int
+	//Offset of the end of the match in the line
+	Offset   int
+	Distance int
+}
+
+//Returns the best match of each line of reader that has a match,
+//the leftmost one with the least distance. When leastOnly is set, only the
+//lines whose best match has the least distance of all lines are returned
+func BestLines(matcher DistanceMatcher, reader io.Reader, leastOnly bool) ([]LineMatch, error) {
+	linereader := NewLineReader(reader)
+	best := NewLineBestMatcher(matcher)
+	best.Reset()
+
+	var matches []LineMatch
+	line := 0
+	for {
+		offset, err := best.FindMatch(linereader)
+		if err == nil {
+			if leastOnly && len(matches) > 0 && best.MatchDistance() < matches[0].Distance {
+				matches = matches[:0]
+			}
+			if !leastOnly || len(matches) == 0 || best.MatchDistance() == matches[0].Distance {
+				matches = append(matches, LineMatch{Line: line, Offset: offset, Distance: best.MatchDistance()})
+			}
+		} else if err == EOL {
+			line++
+			best.Reset()
+		} else if err == io.EOF {
+			return matches, nil
+		} else {
+			return matches, err
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package streammatch
+
+import (
+	"io"
+)
+
+//Backward Nondeterministic DAWG Matching
+type BNDM struct {
+	pattern []byte
+	masks   [256]uint64
+
+	//stream
+	buf    []byte
+	offset int
+	buflen int
+	//Start of the current window in buf. It may be past buflen,
+	//meaning that the bytes up to it must be skipped
+	pos int
+
+	//err
+	lasterr error
+}
+
+//Creates a BNDM matcher.
+//Patterns longer than a machine word fall back to KMP
+func NewBNDM(pattern []byte) Matcher {
+	plen := len(pattern)
+	if plen > wordSize {
+		return NewKMP(pattern)
+	}
+
+	bsize := 2 * plen
+
+	if defaultBufSize > bsize {
+		bsize = defaultBufSize
+	}
+
+	buf := make([]byte, bsize)
+
+	return &BNDM{pattern: pattern, masks: bndm_computeMasks(pattern), buf: buf}
+}
+
+//Bit plen-1-i of masks[c] is 1 when pattern[i] == c
+func bndm_computeMasks(pattern []byte) (masks [256]uint64) {
+	plen := len(pattern)
+	for i, c := range pattern {
+		masks[c] |= 1 << uint(plen-1-i)
+	}
+	return masks
+}
+
+func (bndm *BNDM) Reset() {
+	bndm.offset = 0
+	bndm.buflen = 0
+	bndm.pos = 0
+}
+
+func (bndm *BNDM) FindMatch(reader io.Reader) (int, error) {
+	plen := len(bndm.pattern)
+
+	if plen == 0 {
+		return 0, EmptyPatternError
+	}
+
+	prefixbit := uint64(1) << uint(plen-1)
+
+	offset, buflen, pos := bndm.offset, bndm.buflen, bndm.pos
+	for {
+		//The window is not fully buffered
+		if pos+plen > buflen {
+			if bndm.lasterr != nil {
+				//Save data and report error
+				bndm.offset, bndm.buflen, bndm.pos = offset, buflen, pos
+				lasterr := bndm.lasterr
+				bndm.lasterr = nil
+				return -1, lasterr
+			}
+
+			//Discard everything before the window
+			if pos >= buflen {
+				offset += buflen
+				pos -= buflen
+				buflen = 0
+			} else if pos > 0 {
+				copy(bndm.buf, bndm.buf[pos:buflen])
+				offset += pos
+				buflen -= pos
+				pos = 0
+			}
+
+			var n int
+			n, bndm.lasterr = reader.Read(bndm.buf[buflen:])
+			buflen += n
+			continue
+		}
+
+		//Read the window backwards while it is a factor of the pattern
+		window := bndm.buf[pos : pos+plen]
+		state := ^uint64(0)
+		j, last := plen, plen
+		matched := false
+		for state != 0 && j > 0 {
+			state &= bndm.masks[window[j-1]]
+			j--
+			if state&prefixbit != 0 {
+				if j > 0 {
+					//window[j:] is a prefix of the pattern
+					last = j
+				} else {
+					matched = true
+				}
+			}
+			state <<= 1
+		}
+
+		match_offset := offset + pos + plen - 1
+		pos += last
+
+		if matched {
+			//Save data and report match
+			bndm.offset, bndm.buflen, bndm.pos = offset, buflen, pos
+			return match_offset, nil
+		}
+	}
+}
@@ -1 +1,191 @@
 package streammatch
+
+import (
+	"io"
+)
+
+type BoyerMoore struct {
+	pattern    []byte
+	badchar    [256]int
+	goodsuffix []int
+
+	//stream
+	buf    []byte
+	offset int
+	buflen int
+	//Start of the current window in buf. It may be past buflen,
+	//meaning that the bytes up to it must be skipped
+	pos int
+	//Number of bytes at the start of the window known to match (Galil rule)
+	galil int
+
+	//err
+	lasterr error
+}
+
+//Creates a Boyer-Moore matcher
+func NewBoyerMoore(pattern []byte) *BoyerMoore {
+	plen := len(pattern)
+
+	bsize := 2 * plen
+
+	if defaultBufSize > bsize {
+		bsize = defaultBufSize
+	}
+
+	buf := make([]byte, bsize)
+
+	return &BoyerMoore{
+		pattern:    pattern,
+		badchar:    bm_computeBadChar(pattern),
+		goodsuffix: bm_computeGoodSuffix(pattern),
+		buf:        buf,
+	}
+}
+
+//badchar[c] is the distance from the last occurrence of c
+//in pattern[:plen-1] to the end of the pattern
+func bm_computeBadChar(pattern []byte) (badchar [256]int) {
+	plen := len(pattern)
+	for c := 0; c < 256; c++ {
+		badchar[c] = plen
+	}
+	for i := 0; i < plen-1; i++ {
+		badchar[pattern[i]] = plen - 1 - i
+	}
+	return badchar
+}
+
+//suffixes[i] is the length of the longest common suffix
+//of pattern[:i+1] and pattern
+func bm_computeSuffixes(pattern []byte) []int {
+	plen := len(pattern)
+	suffixes := make([]int, plen)
+	suffixes[plen-1] = plen
+
+	f, g := 0, plen-1
+	for i := plen - 2; i >= 0; i-- {
+		if i > g && suffixes[i+plen-1-f] < i-g {
+			suffixes[i] = suffixes[i+plen-1-f]
+		} else {
+			if i < g {
+				g = i
+			}
+			f = i
+			for g >= 0 && pattern[g] == pattern[g+plen-1-f] {
+				g--
+			}
+			suffixes[i] = f - g
+		}
+	}
+	return suffixes
+}
+
+//goodsuffix[i] is the shift to apply when pattern[i+1:] matched
+//but pattern[i] did not. goodsuffix[0] is the period of the pattern
+func bm_computeGoodSuffix(pattern []byte) []int {
+	plen := len(pattern)
+	if plen == 0 {
+		return nil
+	}
+
+	suffixes := bm_computeSuffixes(pattern)
+	goodsuffix := make([]int, plen)
+	for i := 0; i < plen; i++ {
+		goodsuffix[i] = plen
+	}
+
+	//The matched suffix only reappears as a prefix
+	j := 0
+	for i := plen - 1; i >= 0; i-- {
+		if suffixes[i] == i+1 {
+			for ; j < plen-1-i; j++ {
+				if goodsuffix[j] == plen {
+					goodsuffix[j] = plen - 1 - i
+				}
+			}
+		}
+	}
+
+	//The matched suffix reappears inside the pattern
+	for i := 0; i < plen-1; i++ {
+		goodsuffix[plen-1-suffixes[i]] = plen - 1 - i
+	}
+
+	return goodsuffix
+}
+
+func (bm *BoyerMoore) Reset() {
+	bm.offset = 0
+	bm.buflen = 0
+	bm.pos = 0
+	bm.galil = 0
+}
+
+func (bm *BoyerMoore) FindMatch(reader io.Reader) (int, error) {
+	plen := len(bm.pattern)
+
+	if plen == 0 {
+		return 0, EmptyPatternError
+	}
+
+	offset, buflen := bm.offset, bm.buflen
+	pos, galil := bm.pos, bm.galil
+	for {
+		//The window is not fully buffered
+		if pos+plen > buflen {
+			if bm.lasterr != nil {
+				//Save data and report error
+				bm.offset, bm.buflen = offset, buflen
+				bm.pos, bm.galil = pos, galil
+				lasterr := bm.lasterr
+				bm.lasterr = nil
+				return -1, lasterr
+			}
+
+			//Discard everything before the window
+			if pos >= buflen {
+				offset += buflen
+				pos -= buflen
+				buflen = 0
+			} else if pos > 0 {
+				copy(bm.buf, bm.buf[pos:buflen])
+				offset += pos
+				buflen -= pos
+				pos = 0
+			}
+
+			var n int
+			n, bm.lasterr = reader.Read(bm.buf[buflen:])
+			buflen += n
+			continue
+		}
+
+		i := plen - 1
+		for i >= galil && bm.pattern[i] == bm.buf[pos+i] {
+			i--
+		}
+
+		if i < galil {
+			//Match
+			match_offset := offset + pos + plen - 1
+
+			//Shift by the period, its prefix is already known to match
+			pos += bm.goodsuffix[0]
+			galil = plen - bm.goodsuffix[0]
+
+			//Save data and report match
+			bm.offset, bm.buflen = offset, buflen
+			bm.pos, bm.galil = pos, galil
+
+			return match_offset, nil
+		}
+
+		shift := bm.goodsuffix[i]
+		if bc := bm.badchar[bm.buf[pos+i]] - plen + 1 + i; bc > shift {
+			shift = bc
+		}
+		pos += shift
+		galil = 0
+	}
+}
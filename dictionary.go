@@ -0,0 +1,74 @@
+package streammatch
+
+import (
+	"sort"
+)
+
+//Set of words stored in a trie, to look up the words within a few
+//edits of a query. The trie is built by aho_computeCompactTrie rather
+//than aho_computeTrie: a dense row of 256 ints per state does not fit
+//dictionaries of many words, and the compact trie takes a few tens of
+//bytes per state at the cost of a slower walk
+type Dictionary struct {
+	words [][]byte
+
+	trie *ahoCompactTrie
+	//occurrences[s] is the word that ends at state s, -1 if none.
+	//A repeated word ends at the state of its last copy
+	occurrences []int32
+}
+
+//Word of a Dictionary close to a query, see Dictionary.Lookup
+type DictionaryMatch struct {
+	//Index of the word in the words of the dictionary
+	Word     int
+	Distance int
+}
+
+func NewDictionary(words [][]byte) *Dictionary {
+	trie, occurrences := aho_computeCompactTrie(words)
+	return &Dictionary{words: words, trie: trie, occurrences: occurrences}
+}
+
+//Returns the word with index word
+func (dict *Dictionary) Word(word int) []byte {
+	return dict.words[word]
+}
+
+//Returns the words within maxdist insertions, deletions and substitutions
+//of query, by least distance and then by index. The trie is walked along
+//a Levenshtein automaton of query, and the walk leaves a branch as soon
+//as the automaton knows that no word in it can get within maxdist
+func (dict *Dictionary) Lookup(query []byte, maxdist int) []DictionaryMatch {
+	matches := make([]DictionaryMatch, 0)
+	if maxdist < 0 {
+		return matches
+	}
+
+	states := newLevenshteinStates(query, maxdist, true)
+
+	//Pairs of trie and automaton states still to visit
+	stack := [][2]int{{0, 0}}
+	for len(stack) > 0 {
+		node, state := stack[len(stack)-1][0], int32(stack[len(stack)-1][1])
+		stack = stack[:len(stack)-1]
+
+		if word := dict.occurrences[node]; word != -1 && states.distances[state] <= maxdist {
+			matches = append(matches, DictionaryMatch{Word: int(word), Distance: states.distances[state]})
+		}
+
+		dict.trie.edges(node, func(char byte, child int) {
+			if next := states.next(state, char); !states.dead(next) {
+				stack = append(stack, [2]int{child, int(next)})
+			}
+		})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Word < matches[j].Word
+	})
+	return matches
+}
@@ -0,0 +1,101 @@
+package streammatch
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+//foldASCII[c] is the folding of the ASCII byte c
+var foldASCII = fold_makeASCII()
+
+func fold_makeASCII() (table [utf8.RuneSelf]byte) {
+	for c := range table {
+		table[c] = byte(c)
+		if 'A' <= c && c <= 'Z' {
+			table[c] = byte(c + 'a' - 'A')
+		}
+	}
+	return table
+}
+
+//Returns the same rune for every rune of a simple case folding orbit:
+//the lowercase ASCII letter when the orbit has one, otherwise its least rune
+func fold_rune(r rune) rune {
+	if r < utf8.RuneSelf {
+		return rune(foldASCII[r])
+	}
+
+	least := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < least {
+			least = f
+		}
+	}
+	if least < utf8.RuneSelf {
+		return rune(foldASCII[least])
+	}
+	return least
+}
+
+//Folds every rune of text. Invalid UTF-8 is kept as is
+func fold_bytes(text []byte) []byte {
+	folded := make([]byte, 0, len(text))
+	for i := 0; i < len(text); {
+		if text[i] < utf8.RuneSelf {
+			folded = append(folded, foldASCII[text[i]])
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRune(text[i:])
+		if r == utf8.RuneError && size == 1 {
+			folded = append(folded, text[i])
+		} else {
+			folded = utf8.AppendRune(folded, fold_rune(r))
+		}
+		i += size
+	}
+	return folded
+}
+
+//Folds the runes of in into m. An incomplete rune at the end of in
+//is left for the next call unless flush is set
+func fold_transform(m *mappedReader, in []byte, flush bool) int {
+	i := 0
+	for i < len(in) {
+		//ASCII fast path
+		if in[i] < utf8.RuneSelf {
+			m.out = append(m.out, foldASCII[in[i]])
+			i++
+			continue
+		}
+
+		if !flush && !utf8.FullRune(in[i:]) {
+			break
+		}
+		r, size := utf8.DecodeRune(in[i:])
+		if r == utf8.RuneError && size == 1 {
+			m.out = append(m.out, in[i])
+			i++
+			continue
+		}
+
+		start := len(m.out)
+		m.out = utf8.AppendRune(m.out, fold_rune(r))
+		m.mapped(start, i, size)
+		i += size
+	}
+	return i
+}
+
+//Folds the case of text, as NewFoldedMultiMatcher expects of the patterns
+func FoldBytes(text []byte) []byte {
+	return fold_bytes(text)
+}
+
+//Runs matcher over the case folding of the text, so any matcher of
+//patterns folded by FoldBytes ignores case. Offsets refer to the original
+//text, see NewNormalizedMatcher
+func NewFoldedMultiMatcher(matcher MultiMatcher) MultiMatcher {
+	return &normalizedMultiMatcher{matcher: matcher, reader: newMappedReader(fold_transform)}
+}
@@ -0,0 +1,197 @@
+package streammatch
+
+import (
+	"fmt"
+	"io"
+)
+
+type GlobError struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func (err *GlobError) Error() string {
+	return fmt.Sprintf("Invalid glob pattern %q at position %d: %s", err.Expr, err.Pos, err.Msg)
+}
+
+//Parses a glob pattern into a regular expression syntax tree.
+//No wildcard matches '\n', so matches never cross lines
+func glob_parse(expr []byte) (*regexNode, error) {
+	var anyByte byteSet
+	anyByte.add('\n')
+	anyByte.negate()
+
+	node := &regexNode{op: regexConcat}
+	for pos := 0; pos < len(expr); {
+		c := expr[pos]
+		switch c {
+		case '*':
+			//Consecutive stars are the same as one
+			for pos < len(expr) && expr[pos] == '*' {
+				pos++
+			}
+			star := &regexNode{op: regexRepeat, min: 0, max: -1}
+			star.subs = []*regexNode{{op: regexBytes, set: anyByte}}
+			node.subs = append(node.subs, star)
+		case '?':
+			pos++
+			node.subs = append(node.subs, &regexNode{op: regexBytes, set: anyByte})
+		case '[':
+			class, next, err := glob_parseClass(expr, pos)
+			if err != nil {
+				return nil, err
+			}
+			pos = next
+			node.subs = append(node.subs, class)
+		default:
+			if c == '\\' {
+				pos++
+				if pos == len(expr) {
+					return nil, &GlobError{Expr: string(expr), Pos: pos, Msg: "trailing backslash"}
+				}
+				c = expr[pos]
+			}
+			pos++
+			literal := &regexNode{op: regexBytes}
+			literal.set.add(c)
+			node.subs = append(node.subs, literal)
+		}
+	}
+	return node, nil
+}
+
+//Parses the class that starts at expr[start], and returns it with the position after it.
+//A class starting with '!' or '^' is negated, and ']' right after the opening is literal
+func glob_parseClass(expr []byte, start int) (*regexNode, int, error) {
+	fail := func(pos int, msg string) (*regexNode, int, error) {
+		return nil, 0, &GlobError{Expr: string(expr), Pos: pos, Msg: msg}
+	}
+	//Reads a possibly escaped byte of the class
+	classByte := func(pos int) (byte, int, bool) {
+		if expr[pos] == '\\' {
+			pos++
+			if pos == len(expr) {
+				return 0, pos, false
+			}
+		}
+		return expr[pos], pos + 1, true
+	}
+
+	pos := start + 1
+	negate := false
+	if pos < len(expr) && (expr[pos] == '!' || expr[pos] == '^') {
+		negate = true
+		pos++
+	}
+
+	node := &regexNode{op: regexBytes}
+	first := true
+	for {
+		if pos >= len(expr) {
+			return fail(start, "missing ]")
+		}
+		if expr[pos] == ']' && !first {
+			pos++
+			break
+		}
+		first = false
+
+		lo, next, ok := classByte(pos)
+		if !ok {
+			return fail(start, "missing ]")
+		}
+		if lo >= 0x80 {
+			return fail(pos, "non-ASCII characters in classes are not supported")
+		}
+		pos = next
+
+		//Range
+		if pos+1 < len(expr) && expr[pos] == '-' && expr[pos+1] != ']' {
+			hi, next, ok := classByte(pos + 1)
+			if !ok {
+				return fail(start, "missing ]")
+			}
+			if hi >= 0x80 {
+				return fail(pos+1, "non-ASCII characters in classes are not supported")
+			}
+			if lo > hi {
+				return fail(pos+1, "invalid class range")
+			}
+			pos = next
+			node.set.addRange(lo, hi)
+			continue
+		}
+
+		node.set.add(lo)
+	}
+
+	if negate {
+		node.set.add('\n')
+		node.set.negate()
+	}
+	return node, pos, nil
+}
+
+//Glob matcher for one or more patterns. '?' matches any byte, '*' any run
+//of bytes, '[abc]' and '[a-z]' the bytes listed, '[!abc]' the bytes not listed,
+//and '\' makes the next byte literal. Wildcards and negated classes do not match '\n'.
+//Patterns are not anchored, they match anywhere in the text
+type MultiGlob struct {
+	multi *MultiRegex
+}
+
+func NewMultiGlob(globs [][]byte) (*MultiGlob, error) {
+	multi, err := newMultiRegex(globs, glob_parse)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiGlob{multi: multi}, nil
+}
+
+func (g *MultiGlob) Reset() {
+	g.multi.Reset()
+}
+
+func (g *MultiGlob) FindMultipleMatches(reader io.Reader) (int, []int, error) {
+	return g.multi.FindMultipleMatches(reader)
+}
+
+//Returns the smallest i such that text[i:] matches glob pattern, or -1.
+//Used to find where a reported match starts
+func (g *MultiGlob) MatchStart(pattern int, text []byte) int {
+	return g.multi.MatchStart(pattern, text)
+}
+
+//Glob matcher, see MultiGlob for the syntax
+type Glob struct {
+	multi *MultiRegex
+}
+
+func NewGlob(glob []byte) (*Glob, error) {
+	multi, err := newMultiRegex([][]byte{glob}, glob_parse)
+	if err != nil {
+		return nil, err
+	}
+	return &Glob{multi: multi}, nil
+}
+
+func (g *Glob) Reset() {
+	g.multi.Reset()
+}
+
+func (g *Glob) FindMatch(reader io.Reader) (int, error) {
+	offset, _, err := g.multi.FindMultipleMatches(reader)
+	return offset, err
+}
+
+//Returns the smallest i such that text[i:] matches, or -1
+func (g *Glob) MatchStart(text []byte) int {
+	return g.multi.MatchStart(0, text)
+}
+
+//Approximate glob matcher for one or more patterns, see MultiGlob for the
+//syntax and MultiApproxRegex for the distance
+func NewMultiApproxGlob(globs [][]byte, maxdist int) (*MultiApproxRegex, error) {
+	return newMultiApproxRegex(globs, maxdist, glob_parse)
+}
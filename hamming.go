@@ -0,0 +1,126 @@
+package streammatch
+
+import (
+	"io"
+)
+
+//k-mismatch Shift-And: matches the windows of the text with the length of
+//the pattern that differ from it in at most maxdist bytes. No insertions
+//or deletions are allowed
+type Hamming struct {
+	pattern []byte
+	maxdist int
+	//Bit i of masks[c] is set when pattern[i] == c
+	masks [256]uint64
+
+	//States
+	//Bit i of states[d] is set when pattern[:i+1] matches the last bytes
+	//read with at most d mismatches
+	states []uint64
+
+	//stream
+	offset    int
+	buf       []byte
+	buflen    int
+	bufcursor int
+
+	//err
+	lasterr error
+}
+
+//Creates a Hamming distance matcher.
+//Patterns longer than a machine word fall back to Agrep without insertions and deletions
+func NewHamming(pattern []byte, maxdist int) Matcher {
+	if len(pattern) > wordSize {
+		return NewAgrepLimits(pattern, EditLimits{Insertions: 0, Deletions: 0, Substitutions: -1, Total: maxdist})
+	}
+
+	var masks [256]uint64
+	for i, c := range pattern {
+		masks[c] |= 1 << uint(i)
+	}
+
+	states := 0
+	if maxdist >= 0 {
+		states = maxdist + 1
+	}
+
+	return &Hamming{
+		pattern: pattern,
+		maxdist: maxdist,
+		masks:   masks,
+		states:  make([]uint64, states),
+		buf:     make([]byte, defaultBufSize),
+	}
+}
+
+func (ham *Hamming) Reset() {
+	for d := range ham.states {
+		ham.states[d] = 0
+	}
+	ham.offset = 0
+	ham.buflen = 0
+	ham.bufcursor = 0
+}
+
+//Mismatches of the last match, maxdist+1 when the last window read does not match
+func (ham *Hamming) MatchDistance() int {
+	plen := len(ham.pattern)
+	if plen == 0 {
+		return 0
+	}
+	matchbit := uint64(1) << uint(plen-1)
+	for d, state := range ham.states {
+		if state&matchbit != 0 {
+			return d
+		}
+	}
+	return ham.maxdist + 1
+}
+
+func (ham *Hamming) FindMatch(reader io.Reader) (int, error) {
+	plen := len(ham.pattern)
+
+	if plen == 0 {
+		return 0, EmptyPatternError
+	}
+
+	matchbit := uint64(1) << uint(plen-1)
+	states := ham.states
+	for {
+		if ham.bufcursor >= ham.buflen {
+			if ham.lasterr != nil {
+				lasterr := ham.lasterr
+				ham.lasterr = nil
+				return -1, lasterr
+			}
+			ham.offset += ham.buflen
+			ham.buflen, ham.lasterr = reader.Read(ham.buf)
+			ham.bufcursor = 0
+		}
+
+		for ham.bufcursor < ham.buflen {
+			mask := ham.masks[ham.buf[ham.bufcursor]]
+			ham.bufcursor++
+			if len(states) == 0 {
+				continue
+			}
+
+			//From the most mismatches down, so states[d-1] is still the old one
+			for d := len(states) - 1; d > 0; d-- {
+				states[d] = ((states[d]<<1)|1)&mask | (states[d-1]<<1 | 1)
+			}
+			states[0] = ((states[0] << 1) | 1) & mask
+
+			if states[len(states)-1]&matchbit != 0 {
+				return ham.offset + ham.bufcursor - 1, nil
+			}
+		}
+
+		if ham.lasterr != nil {
+			lasterr := ham.lasterr
+			ham.lasterr = nil
+			return -1, lasterr
+		}
+	}
+}
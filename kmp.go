@@ -14,6 +14,8 @@ type KMP struct {
 	buflen       int
 	ptncursor    int
 	lasterr      error
+	//Case folding, nil when matching is case sensitive
+	folder *mappedReader
 }
 
 func NewKMP(pattern []byte) *KMP {
@@ -31,6 +33,14 @@ func NewKMP(pattern []byte) *KMP {
 	return &KMP{pattern: pattern, failfunction: fail, buf: buf}
 }
 
+//Case-insensitive KMP. The pattern and the text are compared after
+//Unicode simple case folding, and offsets refer to the original text
+func NewKMPFold(pattern []byte) *KMP {
+	kmp := NewKMP(fold_bytes(pattern))
+	kmp.folder = newMappedReader(fold_transform)
+	return kmp
+}
+
 func computeFailFunction(pattern []byte) (failFunction []int) {
 	plen := len(pattern)
 
@@ -77,9 +87,21 @@ func (kmp *KMP) Reset() {
 	kmp.bufcursor = 0
 	kmp.buflen = 0
 	kmp.ptncursor = 0
+	if kmp.folder != nil {
+		kmp.folder.Reset()
+	}
 }
 
 func (kmp *KMP) FindMatch(reader io.Reader) (int, error) {
+	if kmp.folder != nil {
+		kmp.folder.source = reader
+		offset, err := kmp.findMatch(kmp.folder)
+		return kmp.folder.originalOffset(offset), err
+	}
+	return kmp.findMatch(reader)
+}
+
+func (kmp *KMP) findMatch(reader io.Reader) (int, error) {
 	plen := len(kmp.pattern)
 
 	if plen == 0 {
@@ -0,0 +1,254 @@
+package streammatch
+
+import (
+	"io"
+)
+
+//Memory the states of a Levenshtein DFA may take before
+//NewLevenshteinDFA falls back to Sellers
+const levenshteinMaxMemory = 4 << 20
+
+//States of a Levenshtein automaton: the columns of the edit distance DP
+//over the pattern, with the distances above maxdist cut at maxdist+1.
+//Transitions are computed the first time they are followed
+type levenshteinStates struct {
+	pattern []byte
+	//maxdist+1
+	limit int
+	//The pattern must match from the start of the text instead of anywhere,
+	//so the first row grows with the text instead of staying at 0
+	anchored bool
+
+	//classes[c] is the class of byte c: bytes not in the pattern are class 0,
+	//and each distinct byte of the pattern has its own class
+	classes    [256]int32
+	numclasses int
+	//A byte of each class
+	representative []byte
+
+	columns [][]int
+	index   map[string]int32
+	//distances[s] is the distance of the whole pattern at state s, limit if above maxdist
+	distances []int
+	//least[s] is the least distance of column s, limit if above maxdist
+	least []int
+	//transitions[s*numclasses+class] is the state reached from s by a byte
+	//of class, -1 if it was not computed yet
+	transitions []int32
+}
+
+func newLevenshteinStates(pattern []byte, maxdist int, anchored bool) *levenshteinStates {
+	if maxdist < -1 {
+		maxdist = -1
+	}
+
+	ls := &levenshteinStates{
+		pattern:  pattern,
+		limit:    maxdist + 1,
+		anchored: anchored,
+		index:    make(map[string]int32),
+	}
+	ls.representative = append(ls.representative, 0)
+	for _, c := range pattern {
+		if ls.classes[c] == 0 {
+			ls.classes[c] = int32(len(ls.representative))
+			ls.representative = append(ls.representative, c)
+		}
+	}
+	ls.numclasses = len(ls.representative)
+	//A byte outside of the pattern for class 0, which is empty when the pattern has every byte
+	for c := 0; c < 256; c++ {
+		if ls.classes[c] == 0 {
+			ls.representative[0] = byte(c)
+			break
+		}
+	}
+
+	first := make([]int, len(pattern)+1)
+	for i := range first {
+		first[i] = i
+		if first[i] > ls.limit {
+			first[i] = ls.limit
+		}
+	}
+	ls.add(first)
+	return ls
+}
+
+//Returns the state of column, adding it if it is new
+func (ls *levenshteinStates) add(column []int) int32 {
+	key := make([]byte, 2*len(column))
+	for i, val := range column {
+		key[2*i], key[2*i+1] = byte(val), byte(val>>8)
+	}
+	if s, ok := ls.index[string(key)]; ok {
+		return s
+	}
+
+	s := int32(len(ls.columns))
+	ls.index[string(key)] = s
+	ls.columns = append(ls.columns, column)
+
+	least := column[0]
+	for _, val := range column {
+		if val < least {
+			least = val
+		}
+	}
+	ls.distances = append(ls.distances, column[len(column)-1])
+	ls.least = append(ls.least, least)
+	for class := 0; class < ls.numclasses; class++ {
+		ls.transitions = append(ls.transitions, -1)
+	}
+	return s
+}
+
+//Bytes taken by each state: its column, its key and entry in index,
+//its distances and its transitions
+func (ls *levenshteinStates) stateSize() int {
+	rows := len(ls.pattern) + 1
+	return 8*rows + 24 + 2*rows + 32 + 16 + 4*ls.numclasses
+}
+
+//Returns the state reached from state by reading char
+func (ls *levenshteinStates) next(state int32, char byte) int32 {
+	t := int(state)*ls.numclasses + int(ls.classes[char])
+	if ls.transitions[t] >= 0 {
+		return ls.transitions[t]
+	}
+
+	column := ls.columns[state]
+	next := make([]int, len(column))
+	if ls.anchored {
+		next[0] = column[0] + 1
+		if next[0] > ls.limit {
+			next[0] = ls.limit
+		}
+	}
+	for i := 1; i < len(column); i++ {
+		val := column[i] + 1
+		if next[i-1]+1 < val {
+			val = next[i-1] + 1
+		}
+		if ls.pattern[i-1] == char {
+			if column[i-1] < val {
+				val = column[i-1]
+			}
+		} else if column[i-1]+1 < val {
+			val = column[i-1] + 1
+		}
+		if val > ls.limit {
+			val = ls.limit
+		}
+		next[i] = val
+	}
+
+	s := ls.add(next)
+	ls.transitions[t] = s
+	return s
+}
+
+//Reports if no text read from state can get within maxdist of the pattern
+func (ls *levenshteinStates) dead(state int32) bool {
+	return ls.least[state] >= ls.limit
+}
+
+//Levenshtein automaton: a DFA whose states are the columns of the Sellers DP
+//with the distances above maxdist cut at maxdist+1, so each byte of the text
+//costs a single table lookup. The number of states grows quickly with maxdist,
+//it is meant for maxdist up to 2 or so. Reports the same matches as Sellers
+type LevenshteinDFA struct {
+	states  *levenshteinStates
+	maxdist int
+
+	//States
+	state int32
+
+	//stream
+	offset    int
+	buf       []byte
+	buflen    int
+	bufcursor int
+
+	//err
+	lasterr error
+}
+
+//Creates a Levenshtein DFA, or a Sellers matcher when the DFA would take
+//more than a few megabytes
+func NewLevenshteinDFA(pattern []byte, maxdist int) DistanceMatcher {
+	return NewLevenshteinDFAMemory(pattern, maxdist, levenshteinMaxMemory)
+}
+
+//Creates a Levenshtein DFA whose states take up to maxmemory bytes,
+//or a Sellers matcher when they would take more
+func NewLevenshteinDFAMemory(pattern []byte, maxdist int, maxmemory int) DistanceMatcher {
+	if maxdist < -1 {
+		maxdist = -1
+	}
+	if maxdist >= 1<<16-1 {
+		return NewSellers(pattern, maxdist)
+	}
+
+	//Compute every transition, breadth first. The states not expanded yet
+	//take memory too, so all the states found so far count
+	states := newLevenshteinStates(pattern, maxdist, false)
+	for s := 0; s < len(states.columns); s++ {
+		for _, char := range states.representative {
+			states.next(int32(s), char)
+			if len(states.columns)*states.stateSize() > maxmemory {
+				return NewSellers(pattern, maxdist)
+			}
+		}
+	}
+
+	return &LevenshteinDFA{states: states, maxdist: maxdist, buf: make([]byte, defaultBufSize)}
+}
+
+func (dfa *LevenshteinDFA) Reset() {
+	dfa.state = 0
+	dfa.offset = 0
+	dfa.buflen = 0
+	dfa.bufcursor = 0
+}
+
+//Distance between the pattern and the best suffix of the text read so far,
+//maxdist+1 when it is above maxdist
+func (dfa *LevenshteinDFA) MatchDistance() int {
+	return dfa.states.distances[dfa.state]
+}
+
+func (dfa *LevenshteinDFA) FindMatch(reader io.Reader) (int, error) {
+	numclasses := int32(dfa.states.numclasses)
+	classes, transitions, distances := &dfa.states.classes, dfa.states.transitions, dfa.states.distances
+	state := dfa.state
+	for {
+		if dfa.bufcursor >= dfa.buflen {
+			if dfa.lasterr != nil {
+				dfa.state = state
+				lasterr := dfa.lasterr
+				dfa.lasterr = nil
+				return -1, lasterr
+			}
+			dfa.offset += dfa.buflen
+			dfa.buflen, dfa.lasterr = reader.Read(dfa.buf)
+			dfa.bufcursor = 0
+		}
+
+		for dfa.bufcursor < dfa.buflen {
+			state = transitions[state*numclasses+classes[dfa.buf[dfa.bufcursor]]]
+			dfa.bufcursor++
+			if distances[state] <= dfa.maxdist {
+				dfa.state = state
+				return dfa.offset + dfa.bufcursor - 1, nil
+			}
+		}
+
+		if dfa.lasterr != nil {
+			dfa.state = state
+			lasterr := dfa.lasterr
+			dfa.lasterr = nil
+			return -1, lasterr
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package streammatch
+
+import (
+	"io"
+)
+
+//Bytes [start, end) of the transformed text come from a piece of the original
+//text that changed length, and that ends before origend
+type mappedChange struct {
+	start   int
+	end     int
+	origend int
+}
+
+//Reader of a transformation of a text, such as case folding or normalization.
+//Matchers search the transformed text, and the offsets they report are
+//mapped back to the original text
+type mappedReader struct {
+	source io.Reader
+	//Appends the transformation of a prefix of in to out, and returns its length.
+	//Unless flush is set, bytes that need more input may be left for the next call
+	transform func(m *mappedReader, in []byte, flush bool) int
+
+	//Original bytes not transformed yet
+	in    []byte
+	inlen int
+	//Transformed bytes not read yet
+	out       []byte
+	outcursor int
+
+	//Bytes transformed and original bytes consumed since Reset,
+	//not counting the current call to transform
+	transformed int
+	original    int
+	//Pieces that changed length and were not passed by a lookup yet
+	changes []mappedChange
+	//Original minus transformed offset before the first change
+	delta int
+
+	//err
+	lasterr error
+}
+
+func newMappedReader(transform func(m *mappedReader, in []byte, flush bool) int) *mappedReader {
+	return &mappedReader{
+		transform: transform,
+		in:        make([]byte, defaultBufSize),
+		out:       make([]byte, 0, defaultBufSize),
+	}
+}
+
+func (m *mappedReader) Reset() {
+	m.inlen = 0
+	m.out = m.out[:0]
+	m.outcursor = 0
+	m.transformed = 0
+	m.original = 0
+	m.changes = m.changes[:0]
+	m.delta = 0
+}
+
+func (m *mappedReader) Read(p []byte) (int, error) {
+	for m.outcursor >= len(m.out) {
+		if m.lasterr != nil {
+			lasterr := m.lasterr
+			m.lasterr = nil
+			return 0, lasterr
+		}
+
+		//The transform may need more than a full buffer to go on
+		if m.inlen == len(m.in) {
+			in := make([]byte, 2*len(m.in))
+			copy(in, m.in)
+			m.in = in
+		}
+
+		n, err := m.source.Read(m.in[m.inlen:])
+		m.inlen += n
+		m.lasterr = err
+		m.out = m.out[:0]
+		m.outcursor = 0
+
+		//Everything is transformed if no more bytes will come
+		consumed := m.transform(m, m.in[:m.inlen], err != nil)
+		m.transformed += len(m.out)
+		m.original += consumed
+		m.inlen = copy(m.in, m.in[consumed:m.inlen])
+
+		if n == 0 && err == nil {
+			return 0, nil
+		}
+	}
+
+	n := copy(p, m.out[m.outcursor:])
+	m.outcursor += n
+	return n, nil
+}
+
+//Called by transform after appending out[start:] for the original bytes
+//in[from:from+size], so it knows where they came from when their length changed
+func (m *mappedReader) mapped(start int, from int, size int) {
+	if len(m.out)-start == size {
+		return
+	}
+	m.changes = append(m.changes, mappedChange{
+		start:   m.transformed + start,
+		end:     m.transformed + len(m.out),
+		origend: m.original + from + size,
+	})
+}
+
+//Maps the offset of a transformed byte to the offset of the same byte in the
+//original text, or to the last original byte of its piece when the piece
+//changed length. Offsets must be looked up in increasing order, and -1 stays -1
+func (m *mappedReader) originalOffset(offset int) int {
+	if offset < 0 {
+		return offset
+	}
+
+	for len(m.changes) > 0 && m.changes[0].end <= offset {
+		m.delta = m.changes[0].origend - m.changes[0].end
+		m.changes = m.changes[1:]
+	}
+	if len(m.changes) > 0 && m.changes[0].start <= offset {
+		return m.changes[0].origend - 1
+	}
+	return offset + m.delta
+}
@@ -0,0 +1,153 @@
+package streammatch
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"unicode/utf8"
+)
+
+//Ends each chunk a MatcherGroup gives its matchers
+var errGroupChunk = errors.New("end of chunk")
+
+//A match found by a matcher of a MatcherGroup
+type groupMatch struct {
+	offset   int
+	pattern  int
+	distance int
+}
+
+//Reader of a chunk of text, then errGroupChunk
+type groupChunk struct {
+	text []byte
+}
+
+func (c *groupChunk) Read(p []byte) (int, error) {
+	if len(c.text) == 0 {
+		return 0, errGroupChunk
+	}
+	n := copy(p, c.text)
+	c.text = c.text[n:]
+	return n, nil
+}
+
+//Runs single pattern matchers in a single pass over the text, as a
+//MultiMatcher whose i-th pattern is the one of matchers[i]. Each chunk
+//read is given to every matcher, ended by an error they recover from,
+//as the EOL of a LineReader. Chunks end at rune boundaries, so matchers
+//counting runes always see whole runes
+type MatcherGroup struct {
+	matchers []Matcher
+	chunk    groupChunk
+
+	//States
+	//Matches of the last chunk not returned yet, in increasing offsets and patterns
+	pending []groupMatch
+	//Distances of the last match
+	distances []int
+
+	//stream
+	buf []byte
+	//The incomplete rune at the end of the last read is kept in buf
+	buflen int
+
+	//err
+	lasterr error
+}
+
+func NewMatcherGroup(matchers []Matcher) *MatcherGroup {
+	return &MatcherGroup{matchers: matchers, buf: make([]byte, defaultBufSize)}
+}
+
+func (g *MatcherGroup) Reset() {
+	for _, matcher := range g.matchers {
+		matcher.Reset()
+	}
+	g.pending = g.pending[:0]
+	g.buflen = 0
+}
+
+//Distances of the last match, 0 for matchers that are not DistanceMatchers
+func (g *MatcherGroup) MatchDistances() []int {
+	return g.distances
+}
+
+//Length of the longest prefix of text that does not end inside a rune
+func group_runeBoundary(text []byte) int {
+	for i := len(text) - 1; i >= 0 && i >= len(text)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(text[i]) {
+			if !utf8.FullRune(text[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(text)
+}
+
+//Runs every matcher over text and collects their matches
+func (g *MatcherGroup) feed(text []byte) error {
+	for p, matcher := range g.matchers {
+		dm, hasDistance := matcher.(DistanceMatcher)
+		g.chunk.text = text
+		for {
+			offset, err := matcher.FindMatch(&g.chunk)
+			if err == errGroupChunk {
+				break
+			} else if err != nil {
+				return err
+			}
+			match := groupMatch{offset: offset, pattern: p}
+			if hasDistance {
+				match.distance = dm.MatchDistance()
+			}
+			g.pending = append(g.pending, match)
+		}
+	}
+
+	sort.Slice(g.pending, func(i, j int) bool {
+		a, b := g.pending[i], g.pending[j]
+		return a.offset < b.offset || (a.offset == b.offset && a.pattern < b.pattern)
+	})
+	return nil
+}
+
+func (g *MatcherGroup) FindMultipleMatches(reader io.Reader) (int, []int, error) {
+	for {
+		if len(g.pending) > 0 {
+			offset := g.pending[0].offset
+			var occur []int
+			g.distances = g.distances[:0]
+			n := 0
+			for n < len(g.pending) && g.pending[n].offset == offset {
+				occur = append(occur, g.pending[n].pattern)
+				g.distances = append(g.distances, g.pending[n].distance)
+				n++
+			}
+			g.pending = g.pending[:copy(g.pending, g.pending[n:])]
+			return offset, occur, nil
+		}
+
+		if g.lasterr != nil {
+			lasterr := g.lasterr
+			g.lasterr = nil
+			return -1, nil, lasterr
+		}
+
+		var n int
+		n, g.lasterr = reader.Read(g.buf[g.buflen:])
+		g.buflen += n
+
+		//The incomplete rune is given whole with the next read, unless no more bytes will come
+		end := g.buflen
+		if g.lasterr == nil {
+			end = group_runeBoundary(g.buf[:g.buflen])
+		}
+		if end > 0 {
+			if err := g.feed(g.buf[:end]); err != nil {
+				return -1, nil, err
+			}
+		}
+		g.buflen = copy(g.buf, g.buf[end:g.buflen])
+	}
+}
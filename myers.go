@@ -0,0 +1,248 @@
+package streammatch
+
+import (
+	"io"
+)
+
+//Myers' bit-vector edit distance, split in blocks of wordSize rows (Hyyrö)
+type myersAutomaton struct {
+	plen int
+	//peq[b][c] has bit i set when pattern[b*wordSize+i] == c
+	peq [][256]uint64
+	//Bit of the last pattern row inside the last block
+	lastbit uint64
+
+	//States
+	//Positive and negative vertical deltas of the current DP column
+	pv []uint64
+	mv []uint64
+	//Distance of the whole pattern to the best suffix of the text read so far
+	score int
+}
+
+func newMyersAutomaton(pattern []byte) *myersAutomaton {
+	plen := len(pattern)
+	blocks := (plen + wordSize - 1) / wordSize
+
+	peq := make([][256]uint64, blocks)
+	for i, c := range pattern {
+		peq[i/wordSize][c] |= 1 << uint(i%wordSize)
+	}
+
+	aut := &myersAutomaton{
+		plen: plen,
+		peq:  peq,
+		pv:   make([]uint64, blocks),
+		mv:   make([]uint64, blocks),
+	}
+	if plen > 0 {
+		aut.lastbit = 1 << uint((plen-1)%wordSize)
+	}
+	aut.reset()
+	return aut
+}
+
+func (aut *myersAutomaton) reset() {
+	for b := range aut.pv {
+		aut.pv[b] = ^uint64(0)
+		aut.mv[b] = 0
+	}
+	aut.score = aut.plen
+}
+
+//Reads the next byte and returns the new score
+func (aut *myersAutomaton) advance(char byte) int {
+	last := len(aut.pv) - 1
+
+	//The first row of the DP is always 0
+	hin := 0
+	for b := 0; b <= last; b++ {
+		eq := aut.peq[b][char]
+		pv, mv := aut.pv[b], aut.mv[b]
+
+		xv := eq | mv
+		if hin < 0 {
+			eq |= 1
+		}
+		xh := (((eq & pv) + pv) ^ pv) | eq
+
+		ph := mv | ^(xh | pv)
+		mh := pv & xh
+
+		//Horizontal delta at the bottom of the block
+		highbit := uint64(1) << (wordSize - 1)
+		if b == last {
+			highbit = aut.lastbit
+		}
+		hout := 0
+		if ph&highbit != 0 {
+			hout = 1
+		} else if mh&highbit != 0 {
+			hout = -1
+		}
+
+		ph <<= 1
+		mh <<= 1
+		if hin < 0 {
+			mh |= 1
+		} else if hin > 0 {
+			ph |= 1
+		}
+
+		aut.pv[b] = mh | ^(xv | ph)
+		aut.mv[b] = ph & xv
+		hin = hout
+	}
+
+	aut.score += hin
+	return aut.score
+}
+
+//Bit-parallel approximate matcher.
+//Reports the same matches as Sellers
+type Myers struct {
+	automaton *myersAutomaton
+	maxdist   int
+
+	//stream
+	offset    int
+	buf       []byte
+	buflen    int
+	bufcursor int
+
+	//err
+	lasterr error
+}
+
+func NewMyers(pattern []byte, maxdist int) *Myers {
+	buf := make([]byte, defaultBufSize)
+
+	return &Myers{automaton: newMyersAutomaton(pattern), maxdist: maxdist, buf: buf}
+}
+
+func (my *Myers) Reset() {
+	my.automaton.reset()
+
+	my.offset = 0
+	my.buflen = 0
+	my.bufcursor = 0
+}
+
+//Distance between the pattern and the best suffix of the text read so far
+func (my *Myers) MatchDistance() int {
+	return my.automaton.score
+}
+
+func (my *Myers) FindMatch(reader io.Reader) (int, error) {
+	for {
+		if my.bufcursor >= my.buflen {
+			if my.lasterr != nil {
+				lasterr := my.lasterr
+				my.lasterr = nil
+				return -1, lasterr
+			}
+			my.offset += my.buflen
+			my.buflen, my.lasterr = reader.Read(my.buf)
+			my.bufcursor = 0
+		}
+
+		for my.bufcursor < my.buflen {
+			score := my.automaton.advance(my.buf[my.bufcursor])
+			my.bufcursor++
+			if score <= my.maxdist {
+				return my.offset + my.bufcursor - 1, nil
+			}
+		}
+
+		if my.lasterr != nil {
+			lasterr := my.lasterr
+			my.lasterr = nil
+			return -1, lasterr
+		}
+	}
+}
+
+//Approximate matcher for several patterns in a single pass
+type MultiMyers struct {
+	automata []*myersAutomaton
+	maxdist  int
+
+	//States
+	//Distances of the patterns of the last match
+	distances []int
+
+	//stream
+	offset    int
+	buf       []byte
+	buflen    int
+	bufcursor int
+
+	//err
+	lasterr error
+}
+
+func NewMultiMyers(patterns [][]byte, maxdist int) *MultiMyers {
+	automata := make([]*myersAutomaton, len(patterns))
+	for p, pattern := range patterns {
+		automata[p] = newMyersAutomaton(pattern)
+	}
+
+	buf := make([]byte, defaultBufSize)
+
+	return &MultiMyers{automata: automata, maxdist: maxdist, buf: buf}
+}
+
+func (my *MultiMyers) Reset() {
+	for _, aut := range my.automata {
+		aut.reset()
+	}
+
+	my.offset = 0
+	my.buflen = 0
+	my.bufcursor = 0
+}
+
+func (my *MultiMyers) MatchDistances() []int {
+	return my.distances
+}
+
+//Returns the end offset of the next match and the indices
+//of every pattern within maxdist of the text ending there
+func (my *MultiMyers) FindMultipleMatches(reader io.Reader) (int, []int, error) {
+	for {
+		if my.bufcursor >= my.buflen {
+			if my.lasterr != nil {
+				lasterr := my.lasterr
+				my.lasterr = nil
+				return -1, nil, lasterr
+			}
+			my.offset += my.buflen
+			my.buflen, my.lasterr = reader.Read(my.buf)
+			my.bufcursor = 0
+		}
+
+		for my.bufcursor < my.buflen {
+			next := my.buf[my.bufcursor]
+			my.bufcursor++
+
+			var occur []int
+			my.distances = my.distances[:0]
+			for p, aut := range my.automata {
+				if score := aut.advance(next); score <= my.maxdist {
+					occur = append(occur, p)
+					my.distances = append(my.distances, score)
+				}
+			}
+
+			if len(occur) > 0 {
+				return my.offset + my.bufcursor - 1, occur, nil
+			}
+		}
+
+		if my.lasterr != nil {
+			lasterr := my.lasterr
+			my.lasterr = nil
+			return -1, nil, lasterr
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package streammatch
+
+import (
+	"io"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+//Unicode normalization applied to the text before matching
+type Normalization struct {
+	//NFD when set, NFC otherwise
+	Decompose bool
+	//Removes the nonspacing marks, so "ação" becomes "acao"
+	StripMarks bool
+}
+
+func (n Normalization) form() norm.Form {
+	if n.Decompose {
+		return norm.NFD
+	}
+	return norm.NFC
+}
+
+//Normalizes text. Patterns must be normalized before building a matcher
+//for NewNormalizedMatcher or NewNormalizedMultiMatcher
+func (n Normalization) Bytes(text []byte) []byte {
+	m := &mappedReader{}
+	n.transform(m, text, true)
+	return m.out
+}
+
+//Appends the normalization of the segment seg to out
+func (n Normalization) segment(out []byte, seg []byte) []byte {
+	if !n.StripMarks {
+		return n.form().Append(out, seg...)
+	}
+
+	decomposed := norm.NFD.Append(nil, seg...)
+	stripped := decomposed[:0]
+	for i := 0; i < len(decomposed); {
+		r, size := utf8.DecodeRune(decomposed[i:])
+		if !unicode.Is(unicode.Mn, r) {
+			stripped = append(stripped, decomposed[i:i+size]...)
+		}
+		i += size
+	}
+
+	if n.Decompose {
+		return append(out, stripped...)
+	}
+	return norm.NFC.Append(out, stripped...)
+}
+
+//Normalizes the segments of in into m. A segment that may continue
+//after the end of in is left for the next call unless flush is set
+func (n Normalization) transform(m *mappedReader, in []byte, flush bool) int {
+	form := n.form()
+	i := 0
+	for i < len(in) {
+		//ASCII fast path: an ASCII byte followed by another is a segment of its own
+		if in[i] < utf8.RuneSelf && i+1 < len(in) && in[i+1] < utf8.RuneSelf {
+			m.out = append(m.out, in[i])
+			i++
+			continue
+		}
+
+		//The boundary may depend on the bytes after it, so the segments
+		//do not depend on how the text is split into reads
+		size := form.NextBoundary(in[i:], flush)
+		if size < 0 || (!flush && i+size == len(in)) {
+			break
+		}
+
+		start := len(m.out)
+		m.out = n.segment(m.out, in[i:i+size])
+		m.mapped(start, i, size)
+		i += size
+	}
+	return i
+}
+
+type normalizedMatcher struct {
+	matcher Matcher
+	reader  *mappedReader
+}
+
+//Runs matcher over the normalization of the text. Offsets refer to the
+//original text: a match ending inside a segment that changed length is
+//reported at the last byte of the segment
+func NewNormalizedMatcher(matcher Matcher, n Normalization) Matcher {
+	return &normalizedMatcher{matcher: matcher, reader: newMappedReader(n.transform)}
+}
+
+func (nm *normalizedMatcher) Reset() {
+	nm.matcher.Reset()
+	nm.reader.Reset()
+}
+
+//Distance of the last match reported by the wrapped matcher,
+//0 when it is not a DistanceMatcher, as exact matchers are not
+func (nm *normalizedMatcher) MatchDistance() int {
+	if dm, ok := nm.matcher.(DistanceMatcher); ok {
+		return dm.MatchDistance()
+	}
+	return 0
+}
+
+func (nm *normalizedMatcher) FindMatch(reader io.Reader) (int, error) {
+	nm.reader.source = reader
+	offset, err := nm.matcher.FindMatch(nm.reader)
+	return nm.reader.originalOffset(offset), err
+}
+
+type normalizedMultiMatcher struct {
+	matcher MultiMatcher
+	reader  *mappedReader
+	//Patterns of the last match
+	occurrences int
+}
+
+//Runs matcher over the normalization of the text, see NewNormalizedMatcher
+func NewNormalizedMultiMatcher(matcher MultiMatcher, n Normalization) MultiMatcher {
+	return &normalizedMultiMatcher{matcher: matcher, reader: newMappedReader(n.transform)}
+}
+
+func (nm *normalizedMultiMatcher) Reset() {
+	nm.matcher.Reset()
+	nm.reader.Reset()
+}
+
+func (nm *normalizedMultiMatcher) FindMultipleMatches(reader io.Reader) (int, []int, error) {
+	nm.reader.source = reader
+	offset, occur, err := nm.matcher.FindMultipleMatches(nm.reader)
+	nm.occurrences = len(occur)
+	return nm.reader.originalOffset(offset), occur, err
+}
+
+//See normalizedMatcher.MatchDistance
+func (nm *normalizedMultiMatcher) MatchDistances() []int {
+	if dm, ok := nm.matcher.(MultiDistanceMatcher); ok {
+		return dm.MatchDistances()
+	}
+	return make([]int, nm.occurrences)
+}
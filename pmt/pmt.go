@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"code.google.com/p/getopt"
 	"fmt"
 	"github.com/RafaelMarinheiro/streammatch"
@@ -11,17 +12,42 @@ import (
 	"os"
 	"path/filepath"
 	"runtime/pprof"
-	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 var (
 	highlightCode = ansi.ColorCode("green+hu:black")
 	alternateCode = ansi.ColorCode("orange+hu:black")
 	resetCode     = ansi.ColorCode("reset")
+
+	//Alignment operations
+	substitutionCode = ansi.ColorCode("yellow+hu:black")
+	insertionCode    = ansi.ColorCode("cyan+hu:black")
+	deletionCode     = ansi.ColorCode("red+hu:black")
 )
 
 const (
 	defaultBufSize = 100 * 4096
+
+	//Single needles up to this long are searched with the bit-parallel matchers,
+	//longer ones with Boyer-Moore
+	bitParallelMaxLen = 64
+	//Bit-parallel needles at least this long are searched with BNDM instead of Shift-Or
+	bndmMinLen = 4
+
+	//Exact searches for at least this many patterns, all at least
+	//wuManberMinLen long, use Wu-Manber instead of Aho-Corasick
+	wuManberMinPatterns = 1000
+	wuManberMinLen      = 4
+	//Smaller exact pattern sets with all patterns at least this long use Set Horspool
+	setHorspoolMinLen = 8
+
+	//Approximate searches for at least this many patterns are filtered with
+	//exact pieces, as long as the pieces are at least approxFilterMinPiece long
+	approxFilterMinPatterns = 16
+	approxFilterMinPiece    = 3
 )
 
 func findFilesMatch(filenamepattern []string) map[string]bool {
@@ -53,8 +79,43 @@ func main() {
 	var help bool
 	var verbose bool
 	var simpleoutput bool
+	var useRegex bool
+	var useGlob bool
+	var ignoreCase bool
+	var runeColumns bool
+	var normalizeForm string
+	var stripAccents bool
+	var hamming bool
+	var transpositions bool
+	var substitutionTable string
+	var showAlignment bool
+	var allEnds bool
+	var bestMatch bool
+	var leastDistance bool
+	costs := streammatch.EditCosts{Insertion: 1, Deletion: 1, Substitution: 1}
+	limits := streammatch.EditLimits{Insertions: -1, Deletions: -1, Substitutions: -1}
 
 	getopt.IntVarLong(&distance, "edit", 'e', "Compute the approximate matching", "max_dist")
+	getopt.BoolVarLong(&hamming, "hamming", 0, "Allow only substitutions in approximate matches")
+	getopt.BoolVarLong(&transpositions, "transpositions", 't', "Count swapping two adjacent bytes as one edit")
+	getopt.IntVarLong(&costs.Insertion, "insertion-cost", 0, "Cost of an extra byte in the text", "cost")
+	getopt.IntVarLong(&costs.Deletion, "deletion-cost", 0, "Cost of a pattern byte missing from the text", "cost")
+	getopt.IntVarLong(&costs.Substitution, "substitution-cost", 0, "Cost of replacing a byte", "cost")
+	getopt.IntVarLong(&costs.Transposition, "transposition-cost", 0, "Cost of swapping two adjacent bytes, 0 to disallow", "cost")
+	getopt.StringVarLong(&substitutionTable, "substitution-table", 0, "Read substitution costs from lines of \"x y cost\"", "filepath")
+	getopt.IntVarLong(&limits.Insertions, "insertions", 0, "Maximum insertions in approximate matches", "count")
+	getopt.IntVarLong(&limits.Deletions, "deletions", 0, "Maximum deletions in approximate matches", "count")
+	getopt.IntVarLong(&limits.Substitutions, "substitutions", 0, "Maximum substitutions in approximate matches", "count")
+	getopt.BoolVarLong(&useRegex, "regex", 'E', "Needles are regular expressions")
+	getopt.BoolVarLong(&useGlob, "glob", 'g', "Needles are glob patterns with ?, * and [...]")
+	getopt.BoolVarLong(&ignoreCase, "ignore-case", 'i', "Ignore case distinctions")
+	getopt.BoolVarLong(&runeColumns, "runes", 'r', "Count columns and edits in characters instead of bytes")
+	getopt.StringVarLong(&normalizeForm, "normalize", 0, "Match under Unicode normalization, nfc or nfd", "form")
+	getopt.BoolVarLong(&stripAccents, "strip-accents", 'a', "Ignore accents and other combining marks")
+	getopt.BoolVarLong(&bestMatch, "best-match", 'B', "Show only the best approximate match of each line and its distance")
+	getopt.BoolVarLong(&leastDistance, "least-distance", 0, "With -B, show only the lines with the least distance of each file")
+	getopt.BoolVarLong(&allEnds, "all-ends", 0, "Show every end of approximate matches instead of the best of each run")
+	getopt.BoolVarLong(&showAlignment, "alignment", 0, "Show the edit operations of approximate matches")
 	getopt.StringVarLong(&patternFile, "pattern", 'p', "Use line-break separated patterns from a file", "filepath")
 	getopt.StringVarLong(&cpuprofile, "cpuprofile", 0, "Write cpuprofile file", "path")
 	getopt.StringVarLong(&memprofile, "memprofile", 0, "Write memprofile file", "path")
@@ -67,7 +128,9 @@ func main() {
 		getopt.PrintUsage(os.Stderr)
 		fmt.Fprintf(os.Stderr, "needle - only if -p was not used\n")
 		fmt.Fprint(os.Stderr, "haystack\n")
+		fmt.Fprint(os.Stderr, "pmt suggest -p dictionary [-e max_dist] word ... - lines of dictionary close to each word\n")
 	})
+
 	getopt.Parse()
 
 	if cpuprofile != "" {
@@ -84,6 +147,22 @@ func main() {
 		return
 	}
 
+	//pmt suggest looks words up in the lines of the -p file
+	if getopt.NArgs() > 0 && getopt.Args()[0] == "suggest" {
+		queries := getopt.Args()[1:]
+		if patternFile == "" || len(queries) < 1 {
+			fmt.Fprintf(os.Stderr, "Suggestions need a dictionary from -p and words to look up\n")
+			getopt.Usage()
+			os.Exit(1)
+		}
+		words, err := readLinesFromFile(patternFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printSuggestions(words, queries, distance, simpleoutput)
+		return
+	}
+
 	var patterns []string
 	var files []string
 
@@ -112,9 +191,129 @@ func main() {
 
 	fileset := findFilesMatch(files)
 
-	if distance == 0 {
+	bpatterns := make([][]byte, len(patterns))
+	for i, pattern := range patterns {
+		bpatterns[i] = []byte(pattern)
+	}
+
+	var normalization *streammatch.Normalization
+	if normalizeForm != "" || stripAccents {
+		normalization = &streammatch.Normalization{StripMarks: stripAccents}
+		switch strings.ToLower(normalizeForm) {
+		case "", "nfc":
+		case "nfd":
+			normalization.Decompose = true
+		default:
+			log.Fatalf("Unknown normalization form %q", normalizeForm)
+		}
+		for i := range bpatterns {
+			bpatterns[i] = normalization.Bytes(bpatterns[i])
+		}
+	}
+
+	var findStart startFinder
+	if normalization != nil {
+		findStart = transformedStart(normalization.Bytes, lengthStart(bpatterns))
+	}
+
+	if transpositions && costs.Transposition <= 0 {
+		costs.Transposition = 1
+	}
+	weighted := costs != streammatch.EditCosts{Insertion: 1, Deletion: 1, Substitution: 1} || substitutionTable != ""
+	perOperation := limits.Insertions >= 0 || limits.Deletions >= 0 || limits.Substitutions >= 0
+
+	if leastDistance && !bestMatch {
+		log.Fatal("Showing only the lines with the least distance needs -B")
+	}
+	if bestMatch && allEnds {
+		log.Fatal("Showing every end of approximate matches is not supported with -B")
+	}
+	if bestMatch && distance == 0 {
+		if useRegex || useGlob {
+			log.Fatal("Showing the best match of each line of regular expressions or glob patterns needs -e")
+		}
+		//Deleting the whole needle bounds the distance of every line
+		longest := 0
+		for _, pattern := range bpatterns {
+			if len(pattern) > longest {
+				longest = len(pattern)
+			}
+		}
+		distance = longest
+		if weighted {
+			distance = longest * costs.Deletion
+		}
+	}
+
+	if perOperation && distance == 0 {
+		log.Fatal("Per-operation limits need -e")
+	}
+
+	var align aligner
+	if showAlignment {
+		if useRegex || useGlob || ignoreCase || runeColumns || normalization != nil {
+			log.Fatal("Alignments are not supported with regular expressions, glob patterns, ignoring case, counting in characters or normalizing")
+		}
+		if hamming {
+			align = approxAligner(bpatterns, &streammatch.EditCosts{Insertion: distance + 1, Deletion: distance + 1, Substitution: 1})
+		} else if weighted {
+			align = approxAligner(bpatterns, &costs)
+		} else {
+			align = approxAligner(bpatterns, nil)
+		}
+	}
+
+	if useRegex && useGlob {
+		log.Fatal("Needles cannot be both regular expressions and glob patterns")
+	}
+
+	if useRegex || useGlob {
+		if ignoreCase || normalization != nil {
+			log.Fatal("Ignoring case or normalizing is not supported with regular expressions or glob patterns")
+		}
+		if perOperation {
+			log.Fatal("Per-operation limits are not supported with regular expressions or glob patterns")
+		}
+		if (runeColumns || hamming) && distance != 0 || weighted {
+			log.Fatal("Counting edits in characters, substitution-only matching or edit costs are not supported with regular expressions or glob patterns")
+		}
+
+		var matcher regexMatcher
+		var err error
+		if useGlob && distance == 0 {
+			matcher, err = streammatch.NewMultiGlob(bpatterns)
+		} else if useGlob {
+			matcher, err = streammatch.NewMultiApproxGlob(bpatterns, distance)
+		} else if distance == 0 {
+			matcher, err = streammatch.NewMultiRegex(bpatterns)
+		} else {
+			matcher, err = streammatch.NewMultiApproxRegex(bpatterns, distance)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		for fp, _ := range fileset {
+			file, err := os.Open(fp)
+			if err != nil {
+				log.Fatal(err)
+			}
+			matches, err := processMultiMatcher(file, bestMultiMatcher(matcher, allEnds, bestMatch))
+
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			printFileMatches(fp, file, patterns, matches, distance, simpleoutput, runeColumns, regexStart(matcher), nil, bestMatch, leastDistance)
+		}
+	} else if distance == 0 && !weighted {
 		if len(patterns) == 1 {
-			matcher := streammatch.NewKMP([]byte(patterns[0]))
+			var matcher streammatch.Matcher
+			if ignoreCase {
+				matcher = streammatch.NewKMPFold(bpatterns[0])
+			} else {
+				matcher = newExactMatcher(bpatterns[0])
+			}
+			matcher = normalizeMatcher(matcher, normalization)
 			for fp, _ := range fileset {
 				file, err := os.Open(fp)
 				if err != nil {
@@ -126,81 +325,115 @@ func main() {
 					log.Fatal(err)
 				}
 
-				if !simpleoutput {
-					printMatches(fp, file, patterns, matches, distance)
-					if len(matches) > 0 {
-						fmt.Println("###")
-					}
-				} else {
-					printSimpleMatches(fp, file, patterns, matches)
-				}
-
+				printFileMatches(fp, file, patterns, matches, distance, simpleoutput, runeColumns, findStart, align, bestMatch, leastDistance)
 			}
 		} else if len(patterns) > 1 {
-			bpatterns := make([][]byte, len(patterns))
-			for i, pattern := range patterns {
-				bpatterns[i] = []byte(pattern)
+			var matcher streammatch.MultiMatcher
+			if ignoreCase {
+				matcher = streammatch.NewAhoCorasickFold(bpatterns)
+			} else {
+				matcher = newExactMultiMatcher(bpatterns)
 			}
-			matcher := streammatch.NewAhoCorasick(bpatterns)
+			matcher = normalizeMultiMatcher(matcher, normalization)
 			for fp, _ := range fileset {
 				file, err := os.Open(fp)
 				if err != nil {
 					log.Fatal(err)
 				}
-				matches, err := processMultiExactMatcher(file, matcher)
+				matches, err := processMultiMatcher(file, matcher)
 
 				if err != nil {
 					log.Fatal(err)
 				}
 
-				if !simpleoutput {
-					printMatches(fp, file, patterns, matches, distance)
-					if len(matches) > 0 {
-						fmt.Println("###")
-					}
-				} else {
-					printSimpleMatches(fp, file, patterns, matches)
-				}
-
+				printFileMatches(fp, file, patterns, matches, distance, simpleoutput, runeColumns, findStart, align, bestMatch, leastDistance)
 			}
 		}
 	} else {
-		matchers := make([]streammatch.Matcher, 0, len(patterns))
-		for i := 0; i < len(patterns); i++ {
-			matchers = append(matchers, streammatch.NewSellers([]byte(patterns[i]), distance))
+		limits.Total = distance
+		if perOperation && (ignoreCase || runeColumns) {
+			log.Fatal("Per-operation limits are not supported when ignoring case or counting edits in characters")
 		}
-
-		for fp, _ := range fileset {
-			file, err := os.Open(fp)
+		if ignoreCase && runeColumns {
+			log.Fatal("Ignoring case is not supported when counting edits in characters")
+		}
+		if hamming && (perOperation || ignoreCase || runeColumns) {
+			log.Fatal("Substitution-only matching is not supported with per-operation limits, ignoring case or counting edits in characters")
+		}
+		if weighted && (perOperation || ignoreCase || runeColumns || hamming) {
+			log.Fatal("Edit costs are not supported with per-operation limits, ignoring case, counting edits in characters or substitution-only matching")
+		}
+		if costs.Insertion < 0 || costs.Deletion < 0 || costs.Substitution < 0 {
+			log.Fatal("Edit costs cannot be negative")
+		}
+		if substitutionTable != "" {
+			table, err := readSubstitutionTable(substitutionTable, costs.Substitution)
 			if err != nil {
 				log.Fatal(err)
 			}
+			costs.SubstitutionTable = table
+		}
 
-			bufreader := bufio.NewReaderSize(file, defaultBufSize)
-			allmatches := make([]matchRecord, 0, 2)
-			for _, matcher := range matchers {
-				_, err := file.Seek(0, 0)
-				if err != nil {
-					log.Fatal(err)
-				}
-				bufreader.Reset(file)
-				matches, err := processSingleExactMatcher(bufreader, matcher, false)
-				if err != nil {
-					log.Fatal(err)
-				}
-				allmatches = append(allmatches, matches...)
+		folded := bpatterns
+		if ignoreCase {
+			folded = make([][]byte, len(bpatterns))
+			for i, pattern := range bpatterns {
+				folded[i] = streammatch.FoldBytes(pattern)
 			}
+		}
 
-			sort.Stable(matchRecordList(allmatches))
+		if hamming {
+			findStart = lengthStart(bpatterns)
+		} else if weighted {
+			findStart = approxStart(bpatterns, &costs)
+		} else if runeColumns {
+			findStart = runeStart(bpatterns)
+		} else if ignoreCase {
+			findStart = transformedStart(streammatch.FoldBytes, approxStart(folded, nil))
+		} else {
+			findStart = approxStart(bpatterns, nil)
+		}
+		if normalization != nil {
+			findStart = transformedStart(normalization.Bytes, findStart)
+		}
 
-			if !simpleoutput {
-				printMatches(fp, file, patterns, allmatches, distance)
-				if len(allmatches) > 0 {
-					fmt.Println("###")
-				}
+		var matcher streammatch.MultiMatcher
+		if !perOperation && !runeColumns && !hamming && !weighted {
+			if ignoreCase {
+				matcher = streammatch.NewFoldedMultiMatcher(bestMultiMatcher(newApproxMultiMatcher(folded, distance), allEnds, bestMatch))
 			} else {
-				printSimpleMatches(fp, file, patterns, allmatches)
+				matcher = bestMultiMatcher(newApproxMultiMatcher(bpatterns, distance), allEnds, bestMatch)
+			}
+		} else {
+			//Matchers of a single pattern, all run in the same pass
+			matchers := make([]streammatch.Matcher, 0, len(patterns))
+			for i := 0; i < len(patterns); i++ {
+				if runeColumns {
+					matchers = append(matchers, streammatch.NewRuneSellers(bpatterns[i], distance))
+				} else if hamming {
+					matchers = append(matchers, streammatch.NewHamming(bpatterns[i], distance))
+				} else if weighted {
+					matchers = append(matchers, streammatch.NewSellersCosts(bpatterns[i], distance, costs))
+				} else {
+					matchers = append(matchers, streammatch.NewAgrepLimits(bpatterns[i], limits))
+				}
 			}
+			matcher = bestMultiMatcher(streammatch.NewMatcherGroup(matchers), allEnds, bestMatch)
+		}
+		matcher = normalizeMultiMatcher(matcher, normalization)
+
+		for fp, _ := range fileset {
+			file, err := os.Open(fp)
+			if err != nil {
+				log.Fatal(err)
+			}
+			matches, err := processMultiMatcher(file, matcher)
+
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			printFileMatches(fp, file, patterns, matches, distance, simpleoutput, runeColumns, findStart, align, bestMatch, leastDistance)
 		}
 	}
 	if memprofile != "" {
@@ -214,47 +447,148 @@ func main() {
 	}
 }
 
-type matchRecord struct {
-	patternindex int
-	line         int
-	lineOffset   int
-	lineSize     int
-	linepos      int
-	isLastLine   bool
+func newExactMatcher(pattern []byte) streammatch.Matcher {
+	if len(pattern) > bitParallelMaxLen {
+		return streammatch.NewBoyerMoore(pattern)
+	} else if len(pattern) >= bndmMinLen {
+		return streammatch.NewBNDM(pattern)
+	}
+	return streammatch.NewShiftOr(pattern)
 }
 
-type matchRecordList []matchRecord
+func minPatternLen(patterns [][]byte) int {
+	minlen := len(patterns[0])
+	for _, pattern := range patterns {
+		if len(pattern) < minlen {
+			minlen = len(pattern)
+		}
+	}
+	return minlen
+}
 
-// Len is part of sort.Interface.
-func (mr matchRecordList) Len() int {
-	m := []matchRecord(mr)
-	return len(m)
+func newExactMultiMatcher(patterns [][]byte) streammatch.MultiMatcher {
+	minlen := minPatternLen(patterns)
+	if len(patterns) >= wuManberMinPatterns && minlen >= wuManberMinLen {
+		return streammatch.NewWuManber(patterns)
+	} else if minlen >= setHorspoolMinLen {
+		return streammatch.NewSetHorspool(patterns)
+	}
+	return streammatch.NewAhoCorasick(patterns)
 }
 
-// Swap is part of sort.Interface.
-func (mr matchRecordList) Swap(i, j int) {
-	m := []matchRecord(mr)
-	m[i], m[j] = m[j], m[i]
+func newApproxMultiMatcher(patterns [][]byte, distance int) streammatch.MultiMatcher {
+	if len(patterns) >= approxFilterMinPatterns {
+		if minPatternLen(patterns)/(distance+1) >= approxFilterMinPiece {
+			return streammatch.NewApproxFilter(patterns, distance)
+		}
+	}
+	return streammatch.NewMultiMyers(patterns, distance)
 }
 
-// Less is part of sort.Interface.
-func (mr matchRecordList) Less(i, j int) bool {
-	m := []matchRecord(mr)
-	ri := m[i]
-	rj := m[j]
-	if ri.line < rj.line {
-		return true
-	} else if ri.line == rj.line {
-		if ri.linepos <= rj.linepos {
-			return true
-		} else {
-			return false
+//Reports only the best match of each run of approximate matches, unless allEnds
+//is set, or only the best match of each line when perLine is set
+func bestMatcher(matcher streammatch.Matcher, allEnds bool, perLine bool) streammatch.Matcher {
+	dm, ok := matcher.(streammatch.DistanceMatcher)
+	if !ok || allEnds {
+		return matcher
+	} else if perLine {
+		return streammatch.NewLineBestMatcher(dm)
+	}
+	return streammatch.NewBestMatcher(dm)
+}
+
+func bestMultiMatcher(matcher streammatch.MultiMatcher, allEnds bool, perLine bool) streammatch.MultiMatcher {
+	dm, ok := matcher.(streammatch.MultiDistanceMatcher)
+	if !ok || allEnds {
+		return matcher
+	} else if perLine {
+		return streammatch.NewMultiLineBestMatcher(dm)
+	}
+	return streammatch.NewMultiBestMatcher(dm)
+}
+
+//Runs matcher over the normalized text, if normalization is not nil
+func normalizeMatcher(matcher streammatch.Matcher, normalization *streammatch.Normalization) streammatch.Matcher {
+	if normalization == nil {
+		return matcher
+	}
+	return streammatch.NewNormalizedMatcher(matcher, *normalization)
+}
+
+func normalizeMultiMatcher(matcher streammatch.MultiMatcher, normalization *streammatch.Normalization) streammatch.MultiMatcher {
+	if normalization == nil {
+		return matcher
+	}
+	return streammatch.NewNormalizedMultiMatcher(matcher, *normalization)
+}
+
+//Finds where a match starts in the transformed line, as matched under
+//normalization or folding, and maps it back to the start of the shortest
+//suffix of line whose transformation is at least as long
+func transformedStart(transform func([]byte) []byte, findStart startFinder) startFinder {
+	return func(match matchRecord, line []byte) int {
+		transformed := transform(line)
+		suffix := len(transformed) - findStart(match, transformed)
+		if suffix <= 0 {
+			return len(line)
 		}
-	} else {
-		return false
+		for i := len(line) - 1; i >= 0; i-- {
+			if utf8.RuneStart(line[i]) && len(transform(line[i:])) >= suffix {
+				return i
+			}
+		}
+		return 0
+	}
+}
+
+//Finds the leftmost start of the best alignment of an approximate match
+func approxStart(patterns [][]byte, costs *streammatch.EditCosts) startFinder {
+	return func(match matchRecord, line []byte) int {
+		start, _ := streammatch.ApproxMatchStart(patterns[match.patternindex], line, costs)
+		return start
+	}
+}
+
+//Same as approxStart with edits counted in runes
+func runeStart(patterns [][]byte) startFinder {
+	return func(match matchRecord, line []byte) int {
+		start, _ := streammatch.ApproxRuneMatchStart(patterns[match.patternindex], line)
+		return start
+	}
+}
+
+//Exact and substitution-only matches are as long as their pattern
+func lengthStart(patterns [][]byte) startFinder {
+	return func(match matchRecord, line []byte) int {
+		return len(line) - len(patterns[match.patternindex])
+	}
+}
+
+//Exact or approximate regular expression or glob matcher
+type regexMatcher interface {
+	streammatch.MultiMatcher
+	MatchStart(pattern int, text []byte) int
+}
+
+func regexStart(matcher regexMatcher) startFinder {
+	return func(match matchRecord, line []byte) int {
+		return matcher.MatchStart(match.patternindex, line)
 	}
 }
 
+type matchRecord struct {
+	patternindex int
+	line         int
+	lineOffset   int
+	lineSize     int
+	linepos      int
+	isLastLine   bool
+	//Column shown in the output, starting at 1
+	column int
+	//Distance of approximate matches
+	distance int
+}
+
 func processSingleExactMatcher(file io.Reader, matcher streammatch.Matcher, needBuffer bool) ([]matchRecord, error) {
 	var reader io.Reader
 
@@ -265,6 +599,7 @@ func processSingleExactMatcher(file io.Reader, matcher streammatch.Matcher, need
 	}
 
 	linereader := streammatch.NewLineReader(reader)
+	distancer, hasDistance := matcher.(streammatch.DistanceMatcher)
 
 	lineOffset := 0
 	line := 0
@@ -274,7 +609,11 @@ func processSingleExactMatcher(file io.Reader, matcher streammatch.Matcher, need
 	for {
 		pos, err := matcher.FindMatch(linereader)
 		if err == nil {
-			matches = append(matches, matchRecord{line: line, lineOffset: lineOffset, linepos: pos})
+			match := matchRecord{line: line, lineOffset: lineOffset, linepos: pos}
+			if hasDistance {
+				match.distance = distancer.MatchDistance()
+			}
+			matches = append(matches, match)
 		} else if err == streammatch.EOL {
 			newLineOffset := linereader.BytesRead()
 			lastLineSize := newLineOffset - lineOffset
@@ -302,9 +641,10 @@ func processSingleExactMatcher(file io.Reader, matcher streammatch.Matcher, need
 	}
 }
 
-func processMultiExactMatcher(file io.Reader, matcher streammatch.MultiMatcher) ([]matchRecord, error) {
+func processMultiMatcher(file io.Reader, matcher streammatch.MultiMatcher) ([]matchRecord, error) {
 	reader := bufio.NewReader(file)
 	linereader := streammatch.NewLineReader(reader)
+	distancer, hasDistance := matcher.(streammatch.MultiDistanceMatcher)
 
 	lineOffset := 0
 	line := 0
@@ -314,8 +654,16 @@ func processMultiExactMatcher(file io.Reader, matcher streammatch.MultiMatcher)
 	for {
 		pos, ptrns, err := matcher.FindMultipleMatches(linereader)
 		if err == nil {
-			for _, ptrn := range ptrns {
-				matches = append(matches, matchRecord{line: line, lineOffset: lineOffset, linepos: pos, patternindex: ptrn})
+			var distances []int
+			if hasDistance {
+				distances = distancer.MatchDistances()
+			}
+			for i, ptrn := range ptrns {
+				match := matchRecord{line: line, lineOffset: lineOffset, linepos: pos, patternindex: ptrn}
+				if hasDistance {
+					match.distance = distances[i]
+				}
+				matches = append(matches, match)
 			}
 		} else if err == streammatch.EOL {
 			newLineOffset := linereader.BytesRead()
@@ -344,29 +692,156 @@ func processMultiExactMatcher(file io.Reader, matcher streammatch.MultiMatcher)
 	}
 }
 
-func printSimpleMatches(title string, reader io.ReaderAt, patterns []string, matches []matchRecord) {
+//Finds where a match starts, given its line up to the end of the match
+type startFinder func(match matchRecord, line []byte) int
+
+//Aligns a match with its pattern, given its line up to the end of the match
+type aligner func(match matchRecord, line []byte) streammatch.Alignment
+
+func approxAligner(patterns [][]byte, costs *streammatch.EditCosts) aligner {
+	return func(match matchRecord, line []byte) streammatch.Alignment {
+		return streammatch.ApproxAlign(patterns[match.patternindex], line, costs)
+	}
+}
+
+func printFileMatches(title string, reader io.ReaderAt, patterns []string, matches []matchRecord, distance int, simpleoutput bool, runeColumns bool, findStart startFinder, align aligner, showDistance bool, leastDistance bool) {
+	if leastDistance {
+		matches = leastDistanceMatches(matches)
+	}
+	setColumns(reader, matches, runeColumns)
+	if !simpleoutput {
+		printMatches(title, reader, patterns, matches, distance, findStart, align, showDistance)
+		if len(matches) > 0 {
+			fmt.Println("###")
+		}
+	} else {
+		printSimpleMatches(title, reader, patterns, matches, align, showDistance)
+	}
+}
+
+//Keeps only the matches with the least distance
+func leastDistanceMatches(matches []matchRecord) []matchRecord {
+	least := make([]matchRecord, 0, len(matches))
 	for _, match := range matches {
-		fmt.Printf("%v %v %d %d\n", title, patterns[match.patternindex], match.line+1, match.linepos+1)
+		if len(least) > 0 && match.distance < least[0].distance {
+			least = least[:0]
+		}
+		if len(least) == 0 || match.distance == least[0].distance {
+			least = append(least, match)
+		}
 	}
+	return least
 }
-func printMatches(title string, reader io.ReaderAt, patterns []string, matches []matchRecord, distance int) {
-	lastLine := -1
+
+//Reads the line of match into line, reusing it when it is big enough
+func readMatchLine(reader io.ReaderAt, match matchRecord, line []byte) []byte {
+	if line == nil || len(line) < match.lineSize {
+		line = make([]byte, match.lineSize)
+	}
+	sectionReader := io.NewSectionReader(reader, int64(match.lineOffset), int64(match.lineSize))
+	cur := 0
+	for {
+		n, err := sectionReader.Read(line[cur:match.lineSize])
+		cur += n
+		if err == io.EOF {
+			break
+		}
+	}
+	return line
+}
+
+//Sets the columns of matches, in bytes or in UTF-8 characters
+func setColumns(reader io.ReaderAt, matches []matchRecord, runeColumns bool) {
+	var prefix []byte
+	for i := range matches {
+		match := &matches[i]
+		if !runeColumns {
+			match.column = match.linepos + 1
+			continue
+		}
+
+		size := match.linepos + 1
+		if size > match.lineSize {
+			size = match.lineSize
+		}
+		if cap(prefix) < size {
+			prefix = make([]byte, size)
+		}
+		prefix = prefix[:size]
+		n, _ := reader.ReadAt(prefix, int64(match.lineOffset))
+		match.column = utf8.RuneCount(prefix[:n])
+	}
+}
+
+func printSimpleMatches(title string, reader io.ReaderAt, patterns []string, matches []matchRecord, align aligner, showDistance bool) {
 	var line []byte
 	for _, match := range matches {
-		if match.line != lastLine {
-			if line == nil || len(line) < match.lineSize {
-				line = make([]byte, match.lineSize)
+		fmt.Printf("%v %v %d %d", title, patterns[match.patternindex], match.line+1, match.column)
+		if showDistance {
+			fmt.Printf(" %d", match.distance)
+		}
+		if align != nil {
+			line = readMatchLine(reader, match, line)
+			end := match.linepos + 1
+			if end > match.lineSize {
+				end = match.lineSize
 			}
-			sectionReader := io.NewSectionReader(reader, int64(match.lineOffset), int64(match.lineSize))
-			cur := 0
-			for {
-				n, err := sectionReader.Read(line[cur:match.lineSize])
-				cur += n
-				if err == io.EOF {
-					break
-				}
+			alignment := align(match, line[:end])
+			fmt.Printf(" %v", alignment.Cigar())
+		}
+		fmt.Println()
+	}
+}
+
+//Colors line[al.Start:al.End] by alignment operation, showing the
+//pattern bytes missing from the text where they were expected
+func renderAlignment(line []byte, pattern []byte, al streammatch.Alignment) string {
+	var out bytes.Buffer
+	j, i := al.Start, 0
+	for k := 0; k < len(al.Ops); {
+		op := al.Ops[k]
+		from, pfrom := j, i
+		for ; k < len(al.Ops) && al.Ops[k] == op; k++ {
+			switch op {
+			case streammatch.EditInsertion:
+				j++
+			case streammatch.EditDeletion:
+				i++
+			case streammatch.EditTransposition:
+				j, i = j+2, i+2
+			default:
+				j, i = j+1, i+1
 			}
 		}
+
+		switch op {
+		case streammatch.EditMatch:
+			out.WriteString(highlightCode)
+		case streammatch.EditInsertion:
+			out.WriteString(insertionCode)
+		case streammatch.EditDeletion:
+			out.WriteString(deletionCode)
+		default:
+			out.WriteString(substitutionCode)
+		}
+		if op == streammatch.EditDeletion {
+			out.Write(pattern[pfrom:i])
+		} else {
+			out.Write(line[from:j])
+		}
+		out.WriteString(resetCode)
+	}
+	return out.String()
+}
+
+//The match starts are guessed from the pattern lengths when findStart is nil
+func printMatches(title string, reader io.ReaderAt, patterns []string, matches []matchRecord, distance int, findStart startFinder, align aligner, showDistance bool) {
+	lastLine := -1
+	var line []byte
+	for _, match := range matches {
+		if match.line != lastLine {
+			line = readMatchLine(reader, match, line)
+		}
 		if lastLine != -1 && match.line > lastLine+1 {
 			fmt.Printf("...\n")
 		}
@@ -374,6 +849,16 @@ func printMatches(title string, reader io.ReaderAt, patterns []string, matches [
 		start := match.linepos - len(patterns[match.patternindex]) + 1
 		end := match.linepos + 1
 		maybe := start - distance
+		if findStart != nil && end <= match.lineSize {
+			start = findStart(match, line[:end])
+			maybe = start
+		}
+		var alignment *streammatch.Alignment
+		if align != nil && end <= match.lineSize {
+			al := align(match, line[:end])
+			alignment = &al
+			start, maybe = al.Start, al.Start
+		}
 
 		if maybe < 0 {
 			maybe = 0
@@ -384,13 +869,18 @@ func printMatches(title string, reader io.ReaderAt, patterns []string, matches [
 		if end > match.lineSize {
 			end = match.lineSize
 		}
-		fmt.Printf("(%v:%4d:%3d) - ", title, match.line+1, match.linepos+1)
+		fmt.Printf("(%v:%4d:%3d) - ", title, match.line+1, match.column)
+		if showDistance {
+			fmt.Printf("[%d] ", match.distance)
+		}
 
 		fmt.Printf("%v", string(line[0:maybe]))
 		if maybe < start {
 			fmt.Printf("%v%v%v", alternateCode, string(line[maybe:start]), resetCode)
 		}
-		if start < end {
+		if alignment != nil {
+			fmt.Printf("%v", renderAlignment(line, []byte(patterns[match.patternindex]), *alignment))
+		} else if start < end {
 			fmt.Printf("%v%v%v", highlightCode, string(line[start:end]), resetCode)
 		}
 		fmt.Printf("%v", string(line[end:match.lineSize]))
@@ -401,6 +891,63 @@ func printMatches(title string, reader io.ReaderAt, patterns []string, matches [
 	}
 }
 
+//Prints the words within distance of each query, closest first
+func printSuggestions(words []string, queries []string, distance int, simpleoutput bool) {
+	bwords := make([][]byte, len(words))
+	for i, word := range words {
+		bwords[i] = []byte(word)
+	}
+	dict := streammatch.NewDictionary(bwords)
+
+	for _, query := range queries {
+		suggestions := dict.Lookup([]byte(query), distance)
+		for _, suggestion := range suggestions {
+			if simpleoutput {
+				fmt.Printf("%v %v %d\n", query, words[suggestion.Word], suggestion.Distance)
+			} else {
+				fmt.Printf("(%v) - [%d] %v%v%v\n", query, suggestion.Distance, highlightCode, words[suggestion.Word], resetCode)
+			}
+		}
+		if !simpleoutput && len(suggestions) > 0 {
+			fmt.Println("###")
+		}
+	}
+}
+
+//Reads lines of "x y cost", where x and y are bytes that
+//cost cost to substitute for each other
+func readSubstitutionTable(filename string, substitution int) (*[256][256]int, error) {
+	lines, err := readLinesFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	table := new([256][256]int)
+	for a := range table {
+		for b := range table[a] {
+			table[a][b] = substitution
+		}
+	}
+
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		cost, err := 0, error(nil)
+		if len(fields) == 3 {
+			cost, err = strconv.Atoi(fields[2])
+		}
+		if len(fields) != 3 || len(fields[0]) != 1 || len(fields[1]) != 1 || err != nil || cost < 0 {
+			return nil, fmt.Errorf("%v:%d: expected \"x y cost\"", filename, i+1)
+		}
+		x, y := fields[0][0], fields[1][0]
+		table[x][y] = cost
+		table[y][x] = cost
+	}
+	return table, nil
+}
+
 func readLinesFromFile(filename string) ([]string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
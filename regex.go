@@ -0,0 +1,286 @@
+package streammatch
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+const (
+	//The lazy DFA cache is flushed when it grows past this many states
+	regexMaxDFAStates = 4096
+)
+
+//Adds to set every instBytes and instMatch reachable from inst without reading.
+//seen marks the instructions already in set
+func (prog *regexProgram) addClosure(set []int, inst int, seen []bool) []int {
+	stack := []int{inst}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[i] {
+			continue
+		}
+		seen[i] = true
+
+		if prog.insts[i].op == instSplit {
+			stack = append(stack, prog.insts[i].out1, prog.insts[i].out)
+		} else {
+			set = append(set, i)
+		}
+	}
+	return set
+}
+
+type regexDFAState struct {
+	//Sorted instructions of the NFA in this state
+	insts []int
+	//Sorted patterns that match ending at the last byte read
+	matches []int
+	//next[c] is the index of the next state plus 1, 0 when not computed yet
+	next [256]int
+}
+
+//DFA built on demand from the NFA states reached during the search.
+//Every state includes the start of the expressions, so it searches
+//for matches ending anywhere
+type regexDFA struct {
+	prog   *regexProgram
+	states []*regexDFAState
+	index  map[string]int
+
+	seen []bool
+}
+
+func newRegexDFA(prog *regexProgram) *regexDFA {
+	return &regexDFA{
+		prog:  prog,
+		index: make(map[string]int),
+		seen:  make([]bool, len(prog.insts)),
+	}
+}
+
+//Returns the state for the closure of seeds and the expression starts
+func (dfa *regexDFA) state(seeds []int) int {
+	for i := range dfa.seen {
+		dfa.seen[i] = false
+	}
+
+	insts := make([]int, 0, len(seeds)+len(dfa.prog.starts))
+	for _, inst := range seeds {
+		insts = dfa.prog.addClosure(insts, inst, dfa.seen)
+	}
+	for _, inst := range dfa.prog.starts {
+		insts = dfa.prog.addClosure(insts, inst, dfa.seen)
+	}
+	sort.Ints(insts)
+
+	key := make([]byte, 4*len(insts))
+	for i, inst := range insts {
+		binary.LittleEndian.PutUint32(key[4*i:], uint32(inst))
+	}
+	if s, ok := dfa.index[string(key)]; ok {
+		return s
+	}
+
+	st := &regexDFAState{insts: insts}
+	for _, inst := range insts {
+		if dfa.prog.insts[inst].op == instMatch {
+			st.matches = append(st.matches, dfa.prog.insts[inst].pattern)
+		}
+	}
+	sort.Ints(st.matches)
+
+	dfa.states = append(dfa.states, st)
+	dfa.index[string(key)] = len(dfa.states) - 1
+	return len(dfa.states) - 1
+}
+
+func (dfa *regexDFA) initial() int {
+	return dfa.state(nil)
+}
+
+//Returns the state after reading char from state s.
+//It may flush the cache, invalidating every other state
+func (dfa *regexDFA) next(s int, char byte) int {
+	st := dfa.states[s]
+	if n := st.next[char]; n != 0 {
+		return n - 1
+	}
+
+	seeds := make([]int, 0, len(st.insts))
+	for _, inst := range st.insts {
+		if dfa.prog.insts[inst].op == instBytes && dfa.prog.insts[inst].set.has(char) {
+			seeds = append(seeds, dfa.prog.insts[inst].out)
+		}
+	}
+
+	if len(dfa.states) >= regexMaxDFAStates {
+		dfa.states = dfa.states[:0]
+		dfa.index = make(map[string]int)
+		return dfa.state(seeds)
+	}
+
+	n := dfa.state(seeds)
+	st.next[char] = n + 1
+	return n
+}
+
+//Regular expression matcher for one or more expressions.
+//The syntax is a subset of RE2 over bytes: concatenation, alternation,
+//grouping, *, +, ?, {m,n}, classes, escapes and '.', which matches any byte but '\n'
+type MultiRegex struct {
+	exprs [][]byte
+	dfa   *regexDFA
+	//Program of the reversed expressions
+	reverse *regexProgram
+
+	//States
+	state int
+
+	//stream
+	offset    int
+	buf       []byte
+	buflen    int
+	bufcursor int
+
+	//err
+	lasterr error
+}
+
+func NewMultiRegex(exprs [][]byte) (*MultiRegex, error) {
+	return newMultiRegex(exprs, regex_parse)
+}
+
+func newMultiRegex(exprs [][]byte, parse func([]byte) (*regexNode, error)) (*MultiRegex, error) {
+	prog, err := regex_compileWith(exprs, false, parse)
+	if err != nil {
+		return nil, err
+	}
+	reverse, err := regex_compileWith(exprs, true, parse)
+	if err != nil {
+		return nil, err
+	}
+
+	re := &MultiRegex{
+		exprs:   exprs,
+		dfa:     newRegexDFA(prog),
+		reverse: reverse,
+		buf:     make([]byte, defaultBufSize),
+	}
+	re.Reset()
+	return re, nil
+}
+
+func (re *MultiRegex) Reset() {
+	re.state = re.dfa.initial()
+	re.offset = 0
+	re.buflen = 0
+	re.bufcursor = 0
+}
+
+func (re *MultiRegex) FindMultipleMatches(reader io.Reader) (int, []int, error) {
+	state, offset := re.state, re.offset
+	buflen, bufcursor := re.buflen, re.bufcursor
+	for {
+		if bufcursor >= buflen {
+			if re.lasterr != nil {
+				re.state, re.offset = state, offset
+				re.buflen, re.bufcursor = buflen, bufcursor
+				lasterr := re.lasterr
+				re.lasterr = nil
+				return -1, nil, lasterr
+			}
+			offset = offset + buflen
+			buflen, re.lasterr = reader.Read(re.buf)
+			bufcursor = 0
+		}
+
+		for bufcursor < buflen {
+			state = re.dfa.next(state, re.buf[bufcursor])
+			bufcursor++
+
+			if matches := re.dfa.states[state].matches; len(matches) > 0 {
+				//Save state and report match
+				re.state, re.offset = state, offset
+				re.buflen, re.bufcursor = buflen, bufcursor
+				return offset + bufcursor - 1, append([]int(nil), matches...), nil
+			}
+		}
+
+		if re.lasterr != nil {
+			//Save state
+			re.state, re.offset = state, offset
+			re.buflen, re.bufcursor = buflen, bufcursor
+			lasterr := re.lasterr
+			re.lasterr = nil
+			return -1, nil, lasterr
+		}
+	}
+}
+
+//Returns the smallest i such that text[i:] matches expression pattern, or -1.
+//Used to find where a reported match starts
+func (re *MultiRegex) MatchStart(pattern int, text []byte) int {
+	prog := re.reverse
+	seen := make([]bool, len(prog.insts))
+	matches := func(set []int) bool {
+		for _, inst := range set {
+			if prog.insts[inst].op == instMatch && prog.insts[inst].pattern == pattern {
+				return true
+			}
+		}
+		return false
+	}
+
+	set := prog.addClosure(nil, prog.starts[pattern], seen)
+	start := -1
+	if matches(set) {
+		start = len(text)
+	}
+
+	//Run the reversed expression backwards from the end of text
+	for i := len(text) - 1; i >= 0 && len(set) > 0; i-- {
+		for j := range seen {
+			seen[j] = false
+		}
+		next := make([]int, 0, len(set))
+		for _, inst := range set {
+			if prog.insts[inst].op == instBytes && prog.insts[inst].set.has(text[i]) {
+				next = prog.addClosure(next, prog.insts[inst].out, seen)
+			}
+		}
+		set = next
+		if matches(set) {
+			start = i
+		}
+	}
+	return start
+}
+
+//Regular expression matcher, see MultiRegex for the syntax
+type Regex struct {
+	multi *MultiRegex
+}
+
+func NewRegex(expr []byte) (*Regex, error) {
+	multi, err := NewMultiRegex([][]byte{expr})
+	if err != nil {
+		return nil, err
+	}
+	return &Regex{multi: multi}, nil
+}
+
+func (re *Regex) Reset() {
+	re.multi.Reset()
+}
+
+func (re *Regex) FindMatch(reader io.Reader) (int, error) {
+	offset, _, err := re.multi.FindMultipleMatches(reader)
+	return offset, err
+}
+
+//Returns the smallest i such that text[i:] matches, or -1
+func (re *Regex) MatchStart(text []byte) int {
+	return re.multi.MatchStart(0, text)
+}
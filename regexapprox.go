@@ -0,0 +1,309 @@
+package streammatch
+
+import (
+	"io"
+	"sort"
+)
+
+//Least number of edits needed to reach each instruction of a regexProgram
+type regexCosts struct {
+	prog    *regexProgram
+	maxdist int
+	//cost[i] is above maxdist when i is not reachable
+	cost []int
+	//Instructions with cost at most maxdist
+	active []int
+	//buckets[d] holds the instructions that got cost d and were not relaxed yet
+	buckets [][]int
+}
+
+func newRegexCosts(prog *regexProgram, maxdist int) *regexCosts {
+	rc := &regexCosts{
+		prog:    prog,
+		maxdist: maxdist,
+		cost:    make([]int, len(prog.insts)),
+		buckets: make([][]int, maxdist+1),
+	}
+	for i := range rc.cost {
+		rc.cost[i] = maxdist + 1
+	}
+	return rc
+}
+
+func (rc *regexCosts) clear() {
+	for _, i := range rc.active {
+		rc.cost[i] = rc.maxdist + 1
+	}
+	rc.active = rc.active[:0]
+}
+
+func (rc *regexCosts) push(inst int, cost int) {
+	if cost > rc.maxdist || cost >= rc.cost[inst] {
+		return
+	}
+	if rc.cost[inst] > rc.maxdist {
+		rc.active = append(rc.active, inst)
+	}
+	rc.cost[inst] = cost
+	rc.buckets[cost] = append(rc.buckets[cost], inst)
+}
+
+//Follows the splits, and the deletions of the bytes the pattern expects
+func (rc *regexCosts) relax() {
+	for d := 0; d <= rc.maxdist; d++ {
+		for k := 0; k < len(rc.buckets[d]); k++ {
+			i := rc.buckets[d][k]
+			if rc.cost[i] != d {
+				continue
+			}
+			inst := &rc.prog.insts[i]
+			switch inst.op {
+			case instSplit:
+				rc.push(inst.out, d)
+				rc.push(inst.out1, d)
+			case instBytes:
+				rc.push(inst.out, d+1)
+			}
+		}
+		rc.buckets[d] = rc.buckets[d][:0]
+	}
+}
+
+//Computes into next the costs after reading char from rc
+func (rc *regexCosts) step(next *regexCosts, char byte) {
+	next.clear()
+	for _, i := range rc.active {
+		cost := rc.cost[i]
+		inst := &rc.prog.insts[i]
+		switch inst.op {
+		case instBytes:
+			//Match or substitution
+			if inst.set.has(char) {
+				next.push(inst.out, cost)
+			} else {
+				next.push(inst.out, cost+1)
+			}
+			//Insertion
+			next.push(i, cost+1)
+		case instMatch:
+			//Insertion after the match
+			next.push(i, cost+1)
+		}
+	}
+}
+
+//Approximate regular expression matcher for one or more expressions.
+//An expression matches text that is within maxdist insertions, deletions and
+//substitutions of some text it matches exactly. Expressions are literals
+//or use the syntax of MultiRegex. For literals it reports the same matches as MultiMyers
+type MultiApproxRegex struct {
+	prog    *regexProgram
+	reverse *regexProgram
+	maxdist int
+
+	//States
+	costs *regexCosts
+	next  *regexCosts
+	//Distances of the expressions of the last match
+	distances []int
+
+	//stream
+	offset    int
+	buf       []byte
+	buflen    int
+	bufcursor int
+
+	//err
+	lasterr error
+}
+
+func NewMultiApproxRegex(exprs [][]byte, maxdist int) (*MultiApproxRegex, error) {
+	return newMultiApproxRegex(exprs, maxdist, regex_parse)
+}
+
+func newMultiApproxRegex(exprs [][]byte, maxdist int, parse func([]byte) (*regexNode, error)) (*MultiApproxRegex, error) {
+	prog, err := regex_compileWith(exprs, false, parse)
+	if err != nil {
+		return nil, err
+	}
+	reverse, err := regex_compileWith(exprs, true, parse)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxdist < -1 {
+		maxdist = -1
+	}
+
+	re := &MultiApproxRegex{
+		prog:    prog,
+		reverse: reverse,
+		maxdist: maxdist,
+		costs:   newRegexCosts(prog, maxdist),
+		next:    newRegexCosts(prog, maxdist),
+		buf:     make([]byte, defaultBufSize),
+	}
+	re.Reset()
+	return re, nil
+}
+
+//Every position may start a match
+func (re *MultiApproxRegex) seed(rc *regexCosts) {
+	for _, start := range re.prog.starts {
+		rc.push(start, 0)
+	}
+	rc.relax()
+}
+
+func (re *MultiApproxRegex) Reset() {
+	re.costs.clear()
+	re.seed(re.costs)
+
+	re.offset = 0
+	re.buflen = 0
+	re.bufcursor = 0
+}
+
+//Reads the next byte and returns the patterns that match ending at it
+func (re *MultiApproxRegex) advance(char byte) []int {
+	re.costs.step(re.next, char)
+	re.seed(re.next)
+	re.costs, re.next = re.next, re.costs
+
+	var occur []int
+	for _, i := range re.costs.active {
+		if re.prog.insts[i].op == instMatch {
+			occur = append(occur, re.prog.insts[i].pattern)
+		}
+	}
+	sort.Ints(occur)
+
+	//An expression may have several match instructions, keep the cheapest
+	re.distances = re.distances[:0]
+	for _, p := range occur {
+		dist := re.maxdist + 1
+		for _, i := range re.costs.active {
+			inst := &re.prog.insts[i]
+			if inst.op == instMatch && inst.pattern == p && re.costs.cost[i] < dist {
+				dist = re.costs.cost[i]
+			}
+		}
+		re.distances = append(re.distances, dist)
+	}
+	return occur
+}
+
+func (re *MultiApproxRegex) MatchDistances() []int {
+	return re.distances
+}
+
+func (re *MultiApproxRegex) FindMultipleMatches(reader io.Reader) (int, []int, error) {
+	for {
+		if re.bufcursor >= re.buflen {
+			if re.lasterr != nil {
+				lasterr := re.lasterr
+				re.lasterr = nil
+				return -1, nil, lasterr
+			}
+			re.offset += re.buflen
+			re.buflen, re.lasterr = reader.Read(re.buf)
+			re.bufcursor = 0
+		}
+
+		for re.bufcursor < re.buflen {
+			occur := re.advance(re.buf[re.bufcursor])
+			re.bufcursor++
+			if len(occur) > 0 {
+				return re.offset + re.bufcursor - 1, occur, nil
+			}
+		}
+
+		if re.lasterr != nil {
+			lasterr := re.lasterr
+			re.lasterr = nil
+			return -1, nil, lasterr
+		}
+	}
+}
+
+//Returns where the best alignment of expression pattern ending at the end
+//of text starts: the leftmost i such that text[i:] is within the least
+//distance of the expression, or -1 if it is above maxdist.
+//Used to find where a reported match starts, see ApproxMatchStart
+func (re *MultiApproxRegex) MatchStart(pattern int, text []byte) int {
+	prog := re.reverse
+	costs, next := newRegexCosts(prog, re.maxdist), newRegexCosts(prog, re.maxdist)
+	//Least cost of the match instructions of pattern, above maxdist if none is active
+	matchCost := func(rc *regexCosts) int {
+		least := re.maxdist + 1
+		for _, i := range rc.active {
+			if prog.insts[i].op == instMatch && prog.insts[i].pattern == pattern && rc.cost[i] < least {
+				least = rc.cost[i]
+			}
+		}
+		return least
+	}
+
+	costs.push(prog.starts[pattern], 0)
+	costs.relax()
+	start, best := -1, re.maxdist+1
+	if cost := matchCost(costs); cost < best {
+		start, best = len(text), cost
+	}
+
+	//Run the reversed expression backwards from the end of text
+	for i := len(text) - 1; i >= 0 && len(costs.active) > 0; i-- {
+		costs.step(next, text[i])
+		next.relax()
+		costs, next = next, costs
+		if cost := matchCost(costs); cost <= best {
+			start, best = i, cost
+		}
+
+		//Costs never decrease, so no earlier start can do better
+		least := re.maxdist + 1
+		for _, i := range costs.active {
+			if costs.cost[i] < least {
+				least = costs.cost[i]
+			}
+		}
+		if least > best {
+			break
+		}
+	}
+	return start
+}
+
+//Approximate regular expression matcher, see MultiApproxRegex
+type ApproxRegex struct {
+	multi *MultiApproxRegex
+}
+
+func NewApproxRegex(expr []byte, maxdist int) (*ApproxRegex, error) {
+	multi, err := NewMultiApproxRegex([][]byte{expr}, maxdist)
+	if err != nil {
+		return nil, err
+	}
+	return &ApproxRegex{multi: multi}, nil
+}
+
+func (re *ApproxRegex) Reset() {
+	re.multi.Reset()
+}
+
+func (re *ApproxRegex) MatchDistance() int {
+	if len(re.multi.distances) == 0 {
+		return re.multi.maxdist + 1
+	}
+	return re.multi.distances[0]
+}
+
+func (re *ApproxRegex) FindMatch(reader io.Reader) (int, error) {
+	offset, _, err := re.multi.FindMultipleMatches(reader)
+	return offset, err
+}
+
+//Returns where the best alignment of the expression ending at the end of text starts, or -1
+func (re *ApproxRegex) MatchStart(text []byte) int {
+	return re.multi.MatchStart(0, text)
+}
@@ -0,0 +1,541 @@
+package streammatch
+
+import (
+	"fmt"
+)
+
+const (
+	//Largest bound accepted in {m,n}
+	regexMaxRepeat = 1000
+	//Largest compiled program accepted
+	regexMaxInsts = 1 << 16
+)
+
+type RegexError struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func (err *RegexError) Error() string {
+	return fmt.Sprintf("Invalid regular expression %q at position %d: %s", err.Expr, err.Pos, err.Msg)
+}
+
+//Set of bytes
+type byteSet [4]uint64
+
+func (set *byteSet) add(c byte) {
+	set[c>>6] |= 1 << (c & 63)
+}
+
+func (set *byteSet) addRange(lo byte, hi byte) {
+	for c := int(lo); c <= int(hi); c++ {
+		set.add(byte(c))
+	}
+}
+
+func (set *byteSet) addSet(other *byteSet) {
+	for i := range set {
+		set[i] |= other[i]
+	}
+}
+
+func (set *byteSet) negate() {
+	for i := range set {
+		set[i] = ^set[i]
+	}
+}
+
+func (set *byteSet) has(c byte) bool {
+	return set[c>>6]&(1<<(c&63)) != 0
+}
+
+type regexOp int
+
+const (
+	regexEmpty regexOp = iota
+	regexBytes
+	regexConcat
+	regexAlternate
+	regexRepeat
+)
+
+type regexNode struct {
+	op regexOp
+	//regexBytes
+	set byteSet
+	//regexConcat, regexAlternate and regexRepeat
+	subs []*regexNode
+	//regexRepeat, max is -1 when unbounded
+	min int
+	max int
+}
+
+type regexParser struct {
+	expr []byte
+	pos  int
+}
+
+//Parses expr into a syntax tree
+func regex_parse(expr []byte) (*regexNode, error) {
+	p := &regexParser{expr: expr}
+	node, err := p.parseAlternate()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.expr) {
+		return nil, p.errorf("unexpected )")
+	}
+	return node, nil
+}
+
+func (p *regexParser) errorf(format string, args ...interface{}) error {
+	return &RegexError{Expr: string(p.expr), Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *regexParser) more() bool {
+	return p.pos < len(p.expr)
+}
+
+func (p *regexParser) peek() byte {
+	return p.expr[p.pos]
+}
+
+func (p *regexParser) parseAlternate() (*regexNode, error) {
+	node, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.more() || p.peek() != '|' {
+		return node, nil
+	}
+
+	alt := &regexNode{op: regexAlternate, subs: []*regexNode{node}}
+	for p.more() && p.peek() == '|' {
+		p.pos++
+		node, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		alt.subs = append(alt.subs, node)
+	}
+	return alt, nil
+}
+
+func (p *regexParser) parseConcat() (*regexNode, error) {
+	concat := &regexNode{op: regexConcat}
+	for p.more() && p.peek() != '|' && p.peek() != ')' {
+		node, err := p.parseRepeat()
+		if err != nil {
+			return nil, err
+		}
+		concat.subs = append(concat.subs, node)
+	}
+
+	if len(concat.subs) == 0 {
+		return &regexNode{op: regexEmpty}, nil
+	} else if len(concat.subs) == 1 {
+		return concat.subs[0], nil
+	}
+	return concat, nil
+}
+
+func (p *regexParser) parseRepeat() (*regexNode, error) {
+	node, err := p.parseAtom()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.more() {
+		min, max := 0, 0
+		switch p.peek() {
+		case '*':
+			min, max = 0, -1
+			p.pos++
+		case '+':
+			min, max = 1, -1
+			p.pos++
+		case '?':
+			min, max = 0, 1
+			p.pos++
+		case '{':
+			var ok bool
+			min, max, ok, err = p.parseBounds()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				//A literal {
+				return node, nil
+			}
+		default:
+			return node, nil
+		}
+		node = &regexNode{op: regexRepeat, subs: []*regexNode{node}, min: min, max: max}
+	}
+	return node, nil
+}
+
+//Parses {m}, {m,} or {m,n}. Anything else is not a repetition
+func (p *regexParser) parseBounds() (min int, max int, ok bool, err error) {
+	start := p.pos
+	p.pos++
+
+	number := func() (int, bool) {
+		n, digits := 0, 0
+		for p.more() && p.peek() >= '0' && p.peek() <= '9' {
+			if n <= regexMaxRepeat {
+				n = 10*n + int(p.peek()-'0')
+			}
+			p.pos++
+			digits++
+		}
+		return n, digits > 0
+	}
+
+	min, ok = number()
+	if !ok || !p.more() {
+		p.pos = start
+		return 0, 0, false, nil
+	}
+
+	max = min
+	if p.peek() == ',' {
+		p.pos++
+		if max, ok = number(); !ok {
+			max = -1
+		}
+	}
+
+	if !p.more() || p.peek() != '}' {
+		p.pos = start
+		return 0, 0, false, nil
+	}
+	p.pos++
+
+	if min > regexMaxRepeat || max > regexMaxRepeat {
+		return 0, 0, false, p.errorf("repetition bound above %d", regexMaxRepeat)
+	} else if max != -1 && max < min {
+		return 0, 0, false, p.errorf("invalid repetition bounds")
+	}
+	return min, max, true, nil
+}
+
+func (p *regexParser) parseAtom() (*regexNode, error) {
+	c := p.peek()
+	switch c {
+	case '(':
+		p.pos++
+		if p.more() && p.peek() == '?' {
+			return nil, p.errorf("flags and special groups are not supported")
+		}
+		node, err := p.parseAlternate()
+		if err != nil {
+			return nil, err
+		}
+		if !p.more() || p.peek() != ')' {
+			return nil, p.errorf("missing )")
+		}
+		p.pos++
+		return node, nil
+	case '[':
+		return p.parseClass()
+	case '.':
+		p.pos++
+		node := &regexNode{op: regexBytes}
+		node.set.add('\n')
+		node.set.negate()
+		return node, nil
+	case '\\':
+		set, err := p.parseEscape()
+		if err != nil {
+			return nil, err
+		}
+		return &regexNode{op: regexBytes, set: set}, nil
+	case '*', '+', '?':
+		return nil, p.errorf("missing argument to repetition operator")
+	case '^', '$':
+		return nil, p.errorf("anchors are not supported")
+	}
+
+	p.pos++
+	node := &regexNode{op: regexBytes}
+	node.set.add(c)
+	return node, nil
+}
+
+var (
+	regexDigits = regex_makeSet("09")
+	regexWord   = regex_makeSet("09AZaz__")
+	regexSpaces = regex_makeSet("\t\n\f\r  ")
+)
+
+//Makes a set from pairs of range bounds
+func regex_makeSet(ranges string) (set byteSet) {
+	for i := 0; i+1 < len(ranges); i += 2 {
+		set.addRange(ranges[i], ranges[i+1])
+	}
+	return set
+}
+
+//Parses an escape sequence, which may stand for a single byte or a set
+func (p *regexParser) parseEscape() (byteSet, error) {
+	var set byteSet
+	p.pos++
+	if !p.more() {
+		return set, p.errorf("trailing backslash")
+	}
+
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'd', 'D':
+		set = regexDigits
+	case 'w', 'W':
+		set = regexWord
+	case 's', 'S':
+		set = regexSpaces
+	case 'n':
+		set.add('\n')
+	case 't':
+		set.add('\t')
+	case 'r':
+		set.add('\r')
+	case 'f':
+		set.add('\f')
+	case 'v':
+		set.add('\v')
+	case 'x':
+		hex := 0
+		for i := 0; i < 2; i++ {
+			if !p.more() {
+				return set, p.errorf("invalid hexadecimal escape")
+			}
+			d := p.peek()
+			switch {
+			case d >= '0' && d <= '9':
+				hex = 16*hex + int(d-'0')
+			case d >= 'a' && d <= 'f':
+				hex = 16*hex + int(d-'a'+10)
+			case d >= 'A' && d <= 'F':
+				hex = 16*hex + int(d-'A'+10)
+			default:
+				return set, p.errorf("invalid hexadecimal escape")
+			}
+			p.pos++
+		}
+		set.add(byte(hex))
+	default:
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			return set, p.errorf("unknown escape \\%c", c)
+		}
+		set.add(c)
+	}
+
+	if c == 'D' || c == 'W' || c == 'S' {
+		set.negate()
+	}
+	return set, nil
+}
+
+//Tells if set has a single byte, and which
+func (set *byteSet) single() (byte, bool) {
+	found, count := byte(0), 0
+	for c := 0; c < 256; c++ {
+		if set.has(byte(c)) {
+			found = byte(c)
+			count++
+		}
+	}
+	return found, count == 1
+}
+
+func (p *regexParser) parseClass() (*regexNode, error) {
+	start := p.pos
+	p.pos++
+
+	negate := false
+	if p.more() && p.peek() == '^' {
+		negate = true
+		p.pos++
+	}
+
+	node := &regexNode{op: regexBytes}
+	first := true
+	for {
+		if !p.more() {
+			p.pos = start
+			return nil, p.errorf("missing ]")
+		}
+		if p.peek() == ']' && !first {
+			p.pos++
+			break
+		}
+		first = false
+
+		lo, err := p.parseClassByte()
+		if err != nil {
+			return nil, err
+		}
+
+		//Range
+		if p.pos+1 < len(p.expr) && p.peek() == '-' && p.expr[p.pos+1] != ']' {
+			p.pos++
+			hi, err := p.parseClassByte()
+			if err != nil {
+				return nil, err
+			}
+			lobyte, lok := lo.single()
+			hibyte, hok := hi.single()
+			if !lok || !hok || lobyte > hibyte {
+				return nil, p.errorf("invalid class range")
+			}
+			node.set.addRange(lobyte, hibyte)
+			continue
+		}
+
+		node.set.addSet(&lo)
+	}
+
+	if negate {
+		node.set.negate()
+	}
+	return node, nil
+}
+
+func (p *regexParser) parseClassByte() (byteSet, error) {
+	if p.peek() == '\\' {
+		return p.parseEscape()
+	}
+
+	var set byteSet
+	c := p.peek()
+	if c >= 0x80 {
+		return set, p.errorf("non-ASCII characters in classes are not supported")
+	}
+	p.pos++
+	set.add(c)
+	return set, nil
+}
+
+type regexInstOp int
+
+const (
+	//Reads a byte in set and goes to out
+	instBytes regexInstOp = iota
+	//Goes to both out and out1 without reading
+	instSplit
+	//pattern matches
+	instMatch
+)
+
+type regexInst struct {
+	op      regexInstOp
+	set     byteSet
+	out     int
+	out1    int
+	pattern int
+}
+
+//Thompson NFA for one or more expressions
+type regexProgram struct {
+	insts []regexInst
+	//Start of each expression
+	starts []int
+}
+
+type regexCompiler struct {
+	prog *regexProgram
+	expr []byte
+	//Compiles concatenations backwards, so the program reads the text backwards
+	reverse bool
+	err     error
+}
+
+//Parses and compiles exprs into a single program.
+//The program matches expression i with instMatch pattern i
+func regex_compile(exprs [][]byte, reverse bool) (*regexProgram, error) {
+	return regex_compileWith(exprs, reverse, regex_parse)
+}
+
+//Same as regex_compile, parsing exprs with parse
+func regex_compileWith(exprs [][]byte, reverse bool, parse func([]byte) (*regexNode, error)) (*regexProgram, error) {
+	c := &regexCompiler{prog: &regexProgram{}, reverse: reverse}
+	for i, expr := range exprs {
+		node, err := parse(expr)
+		if err != nil {
+			return nil, err
+		}
+		c.expr = expr
+		match := c.emit(regexInst{op: instMatch, pattern: i})
+		c.prog.starts = append(c.prog.starts, c.compile(node, match))
+		if c.err != nil {
+			return nil, c.err
+		}
+	}
+	return c.prog, nil
+}
+
+func (c *regexCompiler) emit(inst regexInst) int {
+	if len(c.prog.insts) >= regexMaxInsts {
+		c.err = &RegexError{Expr: string(c.expr), Pos: len(c.expr), Msg: "expression too large"}
+		return 0
+	}
+	c.prog.insts = append(c.prog.insts, inst)
+	return len(c.prog.insts) - 1
+}
+
+//Compiles node so it continues to next after matching, and returns its entry
+func (c *regexCompiler) compile(node *regexNode, next int) int {
+	if c.err != nil {
+		return 0
+	}
+
+	switch node.op {
+	case regexBytes:
+		return c.emit(regexInst{op: instBytes, set: node.set, out: next})
+	case regexConcat:
+		if c.reverse {
+			for _, sub := range node.subs {
+				next = c.compile(sub, next)
+			}
+		} else {
+			for i := len(node.subs) - 1; i >= 0; i-- {
+				next = c.compile(node.subs[i], next)
+			}
+		}
+		return next
+	case regexAlternate:
+		entry := c.compile(node.subs[len(node.subs)-1], next)
+		for i := len(node.subs) - 2; i >= 0; i-- {
+			entry = c.emit(regexInst{op: instSplit, out: c.compile(node.subs[i], next), out1: entry})
+		}
+		return entry
+	case regexRepeat:
+		sub := node.subs[0]
+		if node.max == -1 {
+			//sub*, looping through a split
+			loop := c.emit(regexInst{op: instSplit, out1: next})
+			if c.err != nil {
+				return 0
+			}
+			c.prog.insts[loop].out = c.compile(sub, loop)
+			next = loop
+		} else {
+			//(sub(sub)?)?
+			tail := next
+			for i := node.min; i < node.max; i++ {
+				tail = c.emit(regexInst{op: instSplit, out: c.compile(sub, tail), out1: next})
+			}
+			next = tail
+		}
+		for i := 0; i < node.min; i++ {
+			next = c.compile(sub, next)
+		}
+		return next
+	}
+
+	//regexEmpty
+	return next
+}
@@ -0,0 +1,130 @@
+package streammatch
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+//Decodes text into runes. Each byte of invalid UTF-8 becomes a negative
+//value, so it only equals the same invalid byte
+func rune_decode(text []byte) []rune {
+	runes := make([]rune, 0, len(text))
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRune(text[i:])
+		if r == utf8.RuneError && size == 1 {
+			r = -1 - rune(text[i])
+		}
+		runes = append(runes, r)
+		i += size
+	}
+	return runes
+}
+
+//Sellers over code points instead of bytes: the text is decoded from UTF-8
+//and each edit inserts, deletes or substitutes a whole rune. Matches are
+//reported at the last byte of their last rune
+type RuneSellers struct {
+	pattern []rune
+	maxdist int
+
+	//States
+	dist    []int
+	distptr int
+	//Last row of the current column whose distance is at most maxdist
+	lastactive int
+
+	//stream
+	offset     int
+	runeoffset int
+	buf        []byte
+	buflen     int
+	bufcursor  int
+
+	//err
+	lasterr error
+}
+
+func NewRuneSellers(pattern []byte, maxdist int) *RuneSellers {
+	runes := rune_decode(pattern)
+	plen := len(runes)
+
+	dist := make([]int, 2*(plen+1))
+	for i := 0; i <= plen; i++ {
+		dist[2*i+1] = i
+	}
+	return &RuneSellers{
+		pattern:    runes,
+		maxdist:    maxdist,
+		dist:       dist,
+		lastactive: sellers_initialLastActive(plen, maxdist),
+		buf:        make([]byte, defaultBufSize),
+	}
+}
+
+func (sel *RuneSellers) Reset() {
+	sel.distptr = 0
+	for i := 0; i <= len(sel.pattern); i++ {
+		sel.dist[2*i+1] = i
+	}
+	sel.lastactive = sellers_initialLastActive(len(sel.pattern), sel.maxdist)
+
+	sel.offset = 0
+	sel.runeoffset = 0
+	sel.buflen = 0
+	sel.bufcursor = 0
+}
+
+//Distance of the last match in runes, see Sellers.MatchDistance
+func (sel *RuneSellers) MatchDistance() int {
+	plen := len(sel.pattern)
+	if sel.lastactive < plen {
+		return sel.maxdist + 1
+	}
+	return sel.dist[2*plen+1-sel.distptr]
+}
+
+func (sel *RuneSellers) FindMatch(reader io.Reader) (int, error) {
+	offset, _, err := sel.FindRuneMatch(reader)
+	return offset, err
+}
+
+//Same as FindMatch, also returning the offset of the last rune of the match
+//in runes. Each byte of invalid UTF-8 counts as a rune
+func (sel *RuneSellers) FindRuneMatch(reader io.Reader) (int, int, error) {
+	lenp := len(sel.pattern)
+
+	for {
+		//Read more unless a whole rune is buffered, or no more bytes will come
+		if sel.bufcursor >= sel.buflen || (sel.lasterr == nil && !utf8.FullRune(sel.buf[sel.bufcursor:sel.buflen])) {
+			if sel.bufcursor >= sel.buflen && sel.lasterr != nil {
+				lasterr := sel.lasterr
+				sel.lasterr = nil
+				return -1, -1, lasterr
+			}
+
+			//Keep the incomplete rune
+			kept := copy(sel.buf, sel.buf[sel.bufcursor:sel.buflen])
+			sel.offset += sel.bufcursor
+			sel.buflen = kept
+			sel.bufcursor = 0
+
+			var n int
+			n, sel.lasterr = reader.Read(sel.buf[kept:])
+			sel.buflen += n
+			continue
+		}
+
+		next, size := utf8.DecodeRune(sel.buf[sel.bufcursor:sel.buflen])
+		if next == utf8.RuneError && size == 1 {
+			next = -1 - rune(sel.buf[sel.bufcursor])
+		}
+
+		sel.lastactive = sellers_step(sel.dist, sel.distptr, sel.lastactive, sel.maxdist, sel.pattern, next)
+		sel.distptr = 1 - sel.distptr
+		sel.bufcursor += size
+		sel.runeoffset++
+		if sel.lastactive == lenp {
+			return sel.offset + sel.bufcursor - 1, sel.runeoffset - 1, nil
+		}
+	}
+}
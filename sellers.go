@@ -5,6 +5,20 @@ import (
 	"io"
 )
 
+//Costs of the edit operations of an approximate match
+type EditCosts struct {
+	//A text byte missing from the pattern
+	Insertion int
+	//A pattern byte missing from the text
+	Deletion     int
+	Substitution int
+	//Two adjacent bytes swapped. Transpositions are not allowed when it is not positive
+	Transposition int
+	//When not nil, SubstitutionTable[a][b] replaces Substitution
+	//for reading a where the pattern has b
+	SubstitutionTable *[256][256]int
+}
+
 type Sellers struct {
 	pattern []byte
 	maxdist int
@@ -12,6 +26,9 @@ type Sellers struct {
 	//States
 	dist    []int
 	distptr int
+	//Last row of the current column whose distance is at most maxdist (Ukkonen's cut-off).
+	//Rows below it are never computed and only known to be above maxdist
+	lastactive int
 
 	//stream
 	offset    int
@@ -21,6 +38,16 @@ type Sellers struct {
 
 	//err
 	lasterr error
+
+	//Case folding, nil when matching is case sensitive
+	folder *mappedReader
+
+	//Weighted edits, nil when every edit costs 1
+	costs *EditCosts
+	//Current and two previous columns of the weighted distances
+	columns [3][]int
+	//Last byte read, -1 before any
+	lastchar int
 }
 
 func NewSellers(pattern []byte, maxdist int) *Sellers {
@@ -38,7 +65,89 @@ func NewSellers(pattern []byte, maxdist int) *Sellers {
 	for i := 0; i <= plen; i++ {
 		dist[2*i+1] = i
 	}
-	return &Sellers{pattern: pattern, maxdist: maxdist, dist: dist, buf: buf}
+	return &Sellers{pattern: pattern, maxdist: maxdist, dist: dist, lastactive: sellers_initialLastActive(plen, maxdist), buf: buf}
+}
+
+//Case-insensitive Sellers. Distances are computed between the folded
+//pattern and the folded text, see NewKMPFold
+func NewSellersFold(pattern []byte, maxdist int) *Sellers {
+	sel := NewSellers(fold_bytes(pattern), maxdist)
+	sel.folder = newMappedReader(fold_transform)
+	return sel
+}
+
+//Creates a Sellers matcher where edits have the given costs, and
+//maxdist bounds the total cost of a match. Costs must not be negative
+func NewSellersCosts(pattern []byte, maxdist int, costs EditCosts) *Sellers {
+	sel := NewSellers(pattern, maxdist)
+	sel.costs = &costs
+	for c := range sel.columns {
+		sel.columns[c] = make([]int, len(pattern)+1)
+	}
+	sel.Reset()
+	return sel
+}
+
+//The first column is dist[i] = i
+func sellers_initialLastActive(plen int, maxdist int) int {
+	if maxdist < 0 {
+		return -1
+	} else if maxdist < plen {
+		return maxdist
+	}
+	return plen
+}
+
+//Computes the next column of the Sellers DP after reading next. The current
+//column is dist[2*i+distptr] and the previous one dist[2*i+1-distptr], the new
+//one replaces the previous one. Returns the last active row of the new column.
+//Shared by Sellers and RuneSellers, which only differ in what they compare
+func sellers_step[T byte | rune](dist []int, distptr int, lastactive int, maxdist int, pattern []T, next T) int {
+	lenp := len(pattern)
+	cur := distptr
+	other := 1 - cur
+
+	dist[2*0+cur] = 0
+
+	//Only the row below the last active one can become active
+	top := lastactive + 1
+	if top > lenp {
+		top = lenp
+	}
+
+	last := 0 //dist[cur][i-1]
+	la := -1  //dist[other][i-1]
+	lb := 0   //dist[other][i]
+	for i := 1; i <= top; i++ {
+		la = lb
+		lb = dist[2*i+other]
+
+		val := last + 1
+		if lb+1 < val {
+			val = lb + 1
+		}
+		if la+1 < val {
+			val = la + 1
+		}
+
+		if la < val && next == pattern[i-1] {
+			val = la
+		}
+		dist[2*i+cur] = val
+		last = val
+	}
+
+	//The next column may read the row below top,
+	//all that matters is that it is above maxdist
+	if top < lenp {
+		dist[2*(top+1)+cur] = maxdist + 1
+	}
+
+	lastactive = top
+	for lastactive >= 0 && dist[2*lastactive+cur] > maxdist {
+		lastactive--
+	}
+	return lastactive
 }
 
 func (sel *Sellers) Reset() {
@@ -46,15 +155,88 @@ func (sel *Sellers) Reset() {
 	for i := 0; i <= len(sel.pattern); i++ {
 		sel.dist[2*i+1] = i
 	}
+	sel.lastactive = sellers_initialLastActive(len(sel.pattern), sel.maxdist)
+	if sel.costs != nil {
+		//Before any text only deletions are possible
+		for i := range sel.columns[0] {
+			sel.columns[0][i] = i * sel.costs.Deletion
+		}
+		sel.lastchar = -1
+	}
 
 	sel.offset = 0
 	sel.buflen = 0
 	sel.bufcursor = 0
+	if sel.folder != nil {
+		sel.folder.Reset()
+	}
+}
+
+//Distance between the pattern and the best suffix of the text read so far,
+//the distance of the last match right after FindMatch returns it
+func (sel *Sellers) MatchDistance() int {
+	plen := len(sel.pattern)
+	if sel.costs != nil {
+		return sel.columns[0][plen]
+	}
+	//Rows below lastactive are not computed
+	if sel.lastactive < plen {
+		return sel.maxdist + 1
+	}
+	return sel.dist[2*plen+1-sel.distptr]
 }
 
 func (sel *Sellers) FindMatch(reader io.Reader) (int, error) {
-	lenp := len(sel.pattern)
+	if sel.folder != nil {
+		sel.folder.source = reader
+		offset, err := sel.findMatch(sel.folder)
+		return sel.folder.originalOffset(offset), err
+	}
+	return sel.findMatch(reader)
+}
+
+//Computes the next column of weighted distances and reports if the whole pattern matches
+func (sel *Sellers) advanceWeighted(char byte) bool {
+	costs := sel.costs
+	sel.columns[0], sel.columns[1], sel.columns[2] = sel.columns[2], sel.columns[0], sel.columns[1]
+	cur, prev, prev2 := sel.columns[0], sel.columns[1], sel.columns[2]
+
+	cur[0] = 0
+	for i := 1; i < len(cur); i++ {
+		pc := sel.pattern[i-1]
+
+		//Match or substitution
+		val := prev[i-1]
+		if pc != char {
+			if costs.SubstitutionTable != nil {
+				val += costs.SubstitutionTable[char][pc]
+			} else {
+				val += costs.Substitution
+			}
+		}
+
+		if ins := prev[i] + costs.Insertion; ins < val {
+			val = ins
+		}
+		if del := cur[i-1] + costs.Deletion; del < val {
+			val = del
+		}
+
+		//The last two bytes read are the last two of pattern[:i] swapped
+		if costs.Transposition > 0 && i >= 2 && sel.lastchar >= 0 &&
+			pc == byte(sel.lastchar) && sel.pattern[i-2] == char {
+			if tr := prev2[i-2] + costs.Transposition; tr < val {
+				val = tr
+			}
+		}
+		cur[i] = val
+	}
+
+	sel.lastchar = int(char)
+	return cur[len(cur)-1] <= sel.maxdist
+}
 
+func (sel *Sellers) findWeightedMatch(reader io.Reader) (int, error) {
 	for {
 		if sel.bufcursor >= sel.buflen {
 			if sel.lasterr != nil {
@@ -68,37 +250,46 @@ func (sel *Sellers) FindMatch(reader io.Reader) (int, error) {
 		}
 
 		for sel.bufcursor < sel.buflen {
-			next := sel.buf[sel.bufcursor]
-			// debug()
-			cur := sel.distptr
-			other := 1 - cur
-
-			sel.dist[2*0+cur] = 0
-			last := 0 //sel.dist[cur][i-1]
-			la := -1  //sel.dist[other][i-1]
-			lb := 0   //sel.dist[other][i]
-			for i := 1; i <= lenp; i++ {
-				la = lb
-				lb = sel.dist[2*i+other]
-
-				val := last + 1
-				if lb+1 < val {
-					val = lb + 1
-				}
-				if la+1 < val {
-					val = la + 1
-				}
-
-				if la < val && next == sel.pattern[i-1] {
-					val = la
-				}
-				sel.dist[2*i+cur] = val
-				last = val
+			matched := sel.advanceWeighted(sel.buf[sel.bufcursor])
+			sel.bufcursor++
+			if matched {
+				return sel.offset + sel.bufcursor - 1, nil
 			}
+		}
 
-			sel.distptr = other
+		if sel.lasterr != nil {
+			lasterr := sel.lasterr
+			sel.lasterr = nil
+			return -1, lasterr
+		}
+	}
+}
+
+func (sel *Sellers) findMatch(reader io.Reader) (int, error) {
+	if sel.costs != nil {
+		return sel.findWeightedMatch(reader)
+	}
+
+	lenp := len(sel.pattern)
+
+	for {
+		if sel.bufcursor >= sel.buflen {
+			if sel.lasterr != nil {
+				lasterr := sel.lasterr
+				sel.lasterr = nil
+				return -1, lasterr
+			}
+			sel.offset += sel.buflen
+			sel.buflen, sel.lasterr = reader.Read(sel.buf)
+			sel.bufcursor = 0
+		}
+
+		for sel.bufcursor < sel.buflen {
+			next := sel.buf[sel.bufcursor]
+			sel.lastactive = sellers_step(sel.dist, sel.distptr, sel.lastactive, sel.maxdist, sel.pattern, next)
+			sel.distptr = 1 - sel.distptr
 			sel.bufcursor++
-			if sel.dist[2*lenp+cur] <= sel.maxdist {
+			if sel.lastactive == lenp {
 				return sel.offset + sel.bufcursor - 1, nil
 			}
 		}
@@ -0,0 +1,165 @@
+package streammatch
+
+import (
+	"io"
+)
+
+//Set Horspool multi-pattern matcher. Windows of the shortest pattern
+//length are read backwards on the trie of the reversed patterns, and
+//shifted by the Horspool rule. Reports the same matches as AhoCorasick
+type SetHorspool struct {
+	patterns [][]byte
+
+	//Trie of the reversed patterns
+	trie        [][256]int
+	occurrences []int32
+
+	//Length of the window, the shortest pattern length (at least 1)
+	window int
+	//Number of bytes a window may need to look back, including itself
+	maxlen int
+	shift  [256]int
+
+	//stream
+	buf    []byte
+	offset int
+	buflen int
+	//End of the current window in buf. It may be past buflen,
+	//meaning that the bytes up to it must be skipped
+	pos int
+
+	//err
+	lasterr error
+}
+
+func NewSetHorspool(patterns [][]byte) *SetHorspool {
+	num_patterns := len(patterns)
+
+	reversed := make([][]byte, num_patterns)
+	window, maxlen := 1, 1
+	for p, pattern := range patterns {
+		plen := len(pattern)
+		reversed[p] = make([]byte, plen)
+		for i, c := range pattern {
+			reversed[p][plen-1-i] = c
+		}
+
+		if p == 0 || plen < window {
+			window = plen
+		}
+		if plen > maxlen {
+			maxlen = plen
+		}
+	}
+	//An empty pattern matches at every byte
+	if window < 1 {
+		window = 1
+	}
+
+	trie, occurrences := aho_computeTrie(reversed)
+
+	bsize := 2 * maxlen
+
+	if defaultBufSize > bsize {
+		bsize = defaultBufSize
+	}
+
+	sh := &SetHorspool{
+		patterns:    patterns,
+		trie:        trie,
+		occurrences: occurrences,
+		window:      window,
+		maxlen:      maxlen,
+		shift:       sethorspool_computeShift(patterns, window),
+		buf:         make([]byte, bsize),
+	}
+	sh.Reset()
+	return sh
+}
+
+//shift[c] is the distance from the end of the window to the next
+//window that may end a pattern, knowing the window ends with c
+func sethorspool_computeShift(patterns [][]byte, window int) (shift [256]int) {
+	for c := 0; c < 256; c++ {
+		shift[c] = window
+	}
+	for _, pattern := range patterns {
+		plen := len(pattern)
+		for d := 1; d < window && d < plen; d++ {
+			c := pattern[plen-1-d]
+			if d < shift[c] {
+				shift[c] = d
+			}
+		}
+	}
+	return shift
+}
+
+func (sh *SetHorspool) Reset() {
+	sh.offset = 0
+	sh.buflen = 0
+	sh.pos = sh.window - 1
+}
+
+func (sh *SetHorspool) FindMultipleMatches(reader io.Reader) (int, []int, error) {
+	offset, buflen, pos := sh.offset, sh.buflen, sh.pos
+	for {
+		//The window end is not buffered
+		if pos >= buflen {
+			if sh.lasterr != nil {
+				//Save data and report error
+				sh.offset, sh.buflen, sh.pos = offset, buflen, pos
+				lasterr := sh.lasterr
+				sh.lasterr = nil
+				return -1, nil, lasterr
+			}
+
+			//Keep the bytes the longest pattern may need
+			drop := pos - sh.maxlen + 1
+			if drop > buflen {
+				drop = buflen
+			}
+			if drop > 0 {
+				copy(sh.buf, sh.buf[drop:buflen])
+				offset += drop
+				buflen -= drop
+				pos -= drop
+			}
+
+			var n int
+			n, sh.lasterr = reader.Read(sh.buf[buflen:])
+			buflen += n
+			continue
+		}
+
+		//Read backwards the patterns that end here, shortest first
+		var occur []int
+		state := 0
+		for i := pos; ; i-- {
+			if sh.occurrences[state] != -1 {
+				occur = append(occur, int(sh.occurrences[state]))
+			}
+			if i < 0 || pos-i >= sh.maxlen {
+				break
+			}
+			state = sh.trie[state][sh.buf[i]]
+			if state == 0 {
+				break
+			}
+		}
+
+		match_offset := offset + pos
+		pos += sh.shift[sh.buf[pos]]
+
+		if len(occur) > 0 {
+			//Report the longest first
+			for i, j := 0, len(occur)-1; i < j; i, j = i+1, j-1 {
+				occur[i], occur[j] = occur[j], occur[i]
+			}
+
+			//Save data and report match
+			sh.offset, sh.buflen, sh.pos = offset, buflen, pos
+			return match_offset, occur, nil
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package streammatch
+
+import (
+	"io"
+)
+
+type ShiftOr struct {
+	pattern []byte
+	masks   [256]uint64
+
+	//States
+	//Bit i is 0 when pattern[:i+1] matches the last bytes read
+	state uint64
+
+	//stream
+	offset    int
+	buf       []byte
+	buflen    int
+	bufcursor int
+
+	//err
+	lasterr error
+}
+
+//Creates a Shift-Or matcher.
+//Patterns longer than a machine word fall back to KMP
+func NewShiftOr(pattern []byte) Matcher {
+	if len(pattern) > wordSize {
+		return NewKMP(pattern)
+	}
+
+	buf := make([]byte, defaultBufSize)
+
+	return &ShiftOr{pattern: pattern, masks: shiftor_computeMasks(pattern), state: ^uint64(0), buf: buf}
+}
+
+//Bit i of masks[c] is 0 when pattern[i] == c
+func shiftor_computeMasks(pattern []byte) (masks [256]uint64) {
+	for c := 0; c < 256; c++ {
+		masks[c] = ^uint64(0)
+	}
+	for i, c := range pattern {
+		masks[c] &^= 1 << uint(i)
+	}
+	return masks
+}
+
+func (so *ShiftOr) Reset() {
+	so.state = ^uint64(0)
+	so.offset = 0
+	so.buflen = 0
+	so.bufcursor = 0
+}
+
+func (so *ShiftOr) FindMatch(reader io.Reader) (int, error) {
+	plen := len(so.pattern)
+
+	if plen == 0 {
+		return 0, EmptyPatternError
+	}
+
+	matchbit := uint64(1) << uint(plen-1)
+
+	state, offset := so.state, so.offset
+	buflen, bufcursor := so.buflen, so.bufcursor
+	for {
+		if bufcursor >= buflen {
+			if so.lasterr != nil {
+				so.state, so.offset = state, offset
+				so.buflen, so.bufcursor = buflen, bufcursor
+				lasterr := so.lasterr
+				so.lasterr = nil
+				return -1, lasterr
+			}
+			offset = offset + buflen
+			buflen, so.lasterr = reader.Read(so.buf)
+			bufcursor = 0
+		}
+
+		for bufcursor < buflen {
+			state = (state << 1) | so.masks[so.buf[bufcursor]]
+			bufcursor++
+
+			if state&matchbit == 0 {
+				//Save state and report match
+				so.state, so.offset = state, offset
+				so.buflen, so.bufcursor = buflen, bufcursor
+				return offset + bufcursor - 1, nil
+			}
+		}
+
+		if so.lasterr != nil {
+			//Save state
+			so.state, so.offset = state, offset
+			so.buflen, so.bufcursor = buflen, bufcursor
+			lasterr := so.lasterr
+			so.lasterr = nil
+			return -1, lasterr
+		}
+	}
+}
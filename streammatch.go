@@ -7,6 +7,9 @@ import (
 
 const (
 	defaultBufSize = 4096
+
+	//Number of bits in the words used by the bit-parallel matchers
+	wordSize = 64
 )
 
 var (
@@ -31,3 +34,20 @@ type MultiMatcher interface {
 	Resetter
 	FindMultipleMatches(reader io.Reader) (int, []int, error)
 }
+
+//Approximate Matcher that knows how far its matches are from the pattern
+type DistanceMatcher interface {
+	Matcher
+
+	//Distance of the match last returned by FindMatch
+	MatchDistance() int
+}
+
+//Approximate MultiMatcher that knows how far its matches are from the patterns
+type MultiDistanceMatcher interface {
+	MultiMatcher
+
+	//Distances of the patterns of the match last returned by
+	//FindMultipleMatches, in the same order as the patterns
+	MatchDistances() []int
+}
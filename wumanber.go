@@ -0,0 +1,199 @@
+package streammatch
+
+import (
+	"bytes"
+	"io"
+	"sort"
+)
+
+const (
+	wmTableBits = 16
+	//Pattern sets at least this big hash blocks of 3 bytes instead of 2
+	wmLargePatternSet = 1024
+)
+
+//Wu-Manber multi-pattern matcher. The shift table is built over the last
+//minlen bytes of each pattern, so the window always ends where the
+//candidate patterns end. Reports the same matches as AhoCorasick
+type WuManber struct {
+	patterns [][]byte
+	//Distinct patterns, keeping the last index of repeated ones
+	unique []int
+	minlen int
+	maxlen int
+	block  int
+
+	shift []int
+	//hash[h] lists the patterns whose window ends with a block
+	//that hashes to h, longest first
+	hash [][]int
+
+	//stream
+	buf    []byte
+	offset int
+	buflen int
+	//End of the current window in buf. It may be past buflen,
+	//meaning that the bytes up to it must be skipped
+	pos int
+
+	//err
+	lasterr error
+}
+
+func NewWuManber(patterns [][]byte) *WuManber {
+	last := make(map[string]int)
+	for p, pattern := range patterns {
+		last[string(pattern)] = p
+	}
+	unique := make([]int, 0, len(last))
+	for p, pattern := range patterns {
+		if last[string(pattern)] == p {
+			unique = append(unique, p)
+		}
+	}
+
+	//Without patterns every window is skipped
+	minlen, maxlen := 1, 1
+	for i, p := range unique {
+		plen := len(patterns[p])
+		if i == 0 || plen < minlen {
+			minlen = plen
+		}
+		if i == 0 || plen > maxlen {
+			maxlen = plen
+		}
+	}
+
+	block := 2
+	if minlen < 2 {
+		block = minlen
+	} else if minlen >= 3 && len(unique) >= wmLargePatternSet {
+		block = 3
+	}
+
+	bsize := 2 * maxlen
+
+	if defaultBufSize > bsize {
+		bsize = defaultBufSize
+	}
+
+	wm := &WuManber{
+		patterns: patterns,
+		unique:   unique,
+		minlen:   minlen,
+		maxlen:   maxlen,
+		block:    block,
+		buf:      make([]byte, bsize),
+	}
+	if minlen > 0 {
+		wm.shift, wm.hash = wm.computeTables()
+	}
+	wm.Reset()
+	return wm
+}
+
+func (wm *WuManber) hashBlock(buf []byte, end int) int {
+	h := 0
+	for i := end - wm.block + 1; i <= end; i++ {
+		h = h<<8 | int(buf[i])
+	}
+	return (h ^ h>>wmTableBits) & (1<<wmTableBits - 1)
+}
+
+func (wm *WuManber) computeTables() ([]int, [][]int) {
+	minlen, block := wm.minlen, wm.block
+
+	shift := make([]int, 1<<wmTableBits)
+	for h := range shift {
+		shift[h] = minlen - block + 1
+	}
+	hash := make([][]int, 1<<wmTableBits)
+
+	//Longest patterns first, so the buckets are sorted
+	sorted := append([]int(nil), wm.unique...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(wm.patterns[sorted[i]]) > len(wm.patterns[sorted[j]])
+	})
+
+	for _, p := range sorted {
+		pattern := wm.patterns[p]
+		window := pattern[len(pattern)-minlen:]
+		for q := block; q <= minlen; q++ {
+			h := wm.hashBlock(window, q-1)
+			if minlen-q < shift[h] {
+				shift[h] = minlen - q
+			}
+		}
+		h := wm.hashBlock(window, minlen-1)
+		hash[h] = append(hash[h], p)
+	}
+
+	return shift, hash
+}
+
+func (wm *WuManber) Reset() {
+	wm.offset = 0
+	wm.buflen = 0
+	wm.pos = wm.minlen - 1
+}
+
+func (wm *WuManber) FindMultipleMatches(reader io.Reader) (int, []int, error) {
+	if wm.minlen == 0 {
+		return 0, nil, EmptyPatternError
+	}
+
+	offset, buflen, pos := wm.offset, wm.buflen, wm.pos
+	for {
+		//The window end is not buffered
+		if pos >= buflen {
+			if wm.lasterr != nil {
+				//Save data and report error
+				wm.offset, wm.buflen, wm.pos = offset, buflen, pos
+				lasterr := wm.lasterr
+				wm.lasterr = nil
+				return -1, nil, lasterr
+			}
+
+			//Keep the bytes the longest pattern may need
+			drop := pos - wm.maxlen + 1
+			if drop > buflen {
+				drop = buflen
+			}
+			if drop > 0 {
+				copy(wm.buf, wm.buf[drop:buflen])
+				offset += drop
+				buflen -= drop
+				pos -= drop
+			}
+
+			var n int
+			n, wm.lasterr = reader.Read(wm.buf[buflen:])
+			buflen += n
+			continue
+		}
+
+		h := wm.hashBlock(wm.buf, pos)
+		if shift := wm.shift[h]; shift > 0 {
+			pos += shift
+			continue
+		}
+
+		var occur []int
+		for _, p := range wm.hash[h] {
+			pattern := wm.patterns[p]
+			start := pos - len(pattern) + 1
+			if start >= 0 && bytes.Equal(wm.buf[start:pos+1], pattern) {
+				occur = append(occur, p)
+			}
+		}
+
+		match_offset := offset + pos
+		pos++
+
+		if len(occur) > 0 {
+			//Save data and report match
+			wm.offset, wm.buflen, wm.pos = offset, buflen, pos
+			return match_offset, occur, nil
+		}
+	}
+}